@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
@@ -17,19 +21,74 @@ type App struct {
 	input            *tview.InputField
 	statusBox        *tview.TextView
 	widebox          *WideBox
+	sidebar          *tview.Flex
+	slotbox          *tview.TextView
+	effectbox        *tview.TextView
 	wideboxFakeFocus bool
 	inputMode        InputMode
 	inputText        string
-	spells           *Spells
-	dataChan         chan Spells
-	statusChan       chan string
-	fetchLock        bool
-	eventReg         *EventRegister
+	query            *Query
+	// full text search is used instead of the query if the input starts with "/"
+	fullText   bool
+	index      *SearchIndex
+	searchHits []SearchHit
+	// indices of spells in app.spells in the order they are shown in the list
+	listed []int
+	spells *Spells
+	// filters and sorting set via commands. levelFilter is -1 if unset
+	levelFilter int
+	classFilter string
+	sortBy      string
+	// spellbooks of all characters and the name of the one which is shown,
+	// "" if all spells are shown
+	books      *Spellbooks
+	bookFilter string
+	// spell slots of the selected character, nil if none is selected
+	slots *SlotTracker
+	// the spell which is shown in the widebox atm
+	shownSpell *Spell
+	// problems with custom spells found while loading them
+	diagnostics []Diagnostic
+	// spells that are active atm. It must be accessed only from the main
+	// tview goroutine, that is from input handlers and queued updates
+	effects EffectTracker
+	// wakes up the countdown of effects, see tickEffects
+	effectsChan chan bool
+	// rolls dice with :roll, the rolls are kept in the history
+	roller       *Roller
+	rolls        RollHistory
+	dataChan     chan *spellLoad
+	statusChan   chan string
+	progressChan chan FetchProgress
+	// fetchLock and fetchCancel are shared with the fetch goroutine, so
+	// they are guarded by fetchMu
+	fetchMu   sync.Mutex
+	fetchLock bool
+	eventReg  *EventRegister
+	// cancelled when the app quits, which stops all requests to APIs
+	ctx    context.Context
+	cancel context.CancelFunc
+	// cancels the fetch that is in progress, nil if there is none
+	fetchCancel context.CancelFunc
+	// spells of each source from the last fetch, by names of the sources.
+	// Accessed only while fetching
+	sourceSpells map[string]Spells
+	// the config from which spells were last loaded
+	config *Config
+	// the error of loading the config. While it is set, config is the
+	// default one and must not be saved over the config file
+	configErr error
+	// counts changes of the config made by commands. A fetch that started
+	// before a change doesn't replace the changed config
+	configChanges int
+	// files of the app and the fetcher of API pages
+	env *Env
 }
 
-// Instantiate a new app ready to run
-func newApp() *App {
-	app := App{}
+// Instantiate a new app ready to run, which keeps its files where env says
+func newApp(env *Env) *App {
+	app := App{env: env}
+	app.ctx, app.cancel = context.WithCancel(context.Background())
 
 	defer func() {
 		// perform an app cleanup and after that keep on packing
@@ -51,21 +110,41 @@ func newApp() *App {
 	app.input = getInputField(app.setInputText)
 	app.statusBox = getStatusBox()
 	app.widebox = getWideBox()
+	app.slotbox = getSlotBox()
+	app.effectbox = getEffectBox()
+	app.sidebar = tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(app.slotbox, 0, 1, false).
+		AddItem(app.effectbox, 0, 1, false)
+	app.updateSlots()
+	app.levelFilter = -1
+	app.roller = NewRoller(time.Now().UnixNano())
 	app.setInputMode(InputNormal)
-	app.dataChan = make(chan Spells)
+	app.dataChan = make(chan *spellLoad)
 	app.statusChan = make(chan string)
+	app.progressChan = make(chan FetchProgress)
+	app.effectsChan = make(chan bool, 1)
 
 	// set up channel loops in separate goroutines which wait for data and statuses.
 	// It is important that they are set up before the the first data fetch.
 	go app.waitForData()
 	go app.waitForStatuses()
+	go app.waitForProgress()
 
 	// instantiate a logger
-	l := NewLogger(LogFile)
-	app.eventReg = NewEventRegister(l, app.statusChan)
+	l := NewLogger(env.LogFile)
+	app.eventReg = NewEventRegister(l, app.statusChan, app.progressChan)
 
 	// make sure that spells are initialized if fetching goes wrong
 	app.spells = new(Spells)
+	// spellbooks are loaded only once. They are changed only by commands,
+	// so reloading them with spells would throw away unsaved changes
+	books, err := LoadSpellbooks(env.SpellbookDir)
+	if err != nil {
+		app.eventReg.Register(EventErr, fmt.Sprintf("error while loading spellbooks: %s", err), "Could not load some spellbooks, check logs")
+	}
+	app.books = books
+	app.config = defaultConfig()
+	app.configErr = fmt.Errorf("Config is not loaded yet")
 	app.FetchData(false)
 
 	// set the global input handler
@@ -76,7 +155,8 @@ func newApp() *App {
 		SetDirection(tview.FlexRow).
 		AddItem(tview.NewFlex().
 			AddItem(app.list, 0, 3, true).
-			AddItem(app.widebox.grid, 0, 7, false), 0, 1, false).
+			AddItem(app.widebox.grid, 0, 7, false).
+			AddItem(app.sidebar, 0, 2, false), 0, 1, false).
 		AddItem(tview.NewFlex().SetDirection(tview.FlexColumn).
 			AddItem(app.input, 0, 3, false).
 			AddItem(app.statusBox, 0, 7, false), 1, 0, false)
@@ -99,24 +179,89 @@ func (app *App) Run() {
 		}
 	}()
 
+	go app.tickEffects()
 	app.app.Run()
 }
 
 // Quit the app aka do the cleanup
 func (app *App) Quit() {
+	app.cancel()
 	app.eventReg.logger.Close()
 }
 
+// Counts down active effects every second and reports those that end. It
+// sleeps while no effects are active, until one is activated. Stays open
+// until the app quits
+func (app *App) tickEffects() {
+	for {
+		select {
+		case <-app.ctx.Done():
+			return
+		case <-app.effectsChan:
+		}
+		ticker := time.NewTicker(time.Second)
+		for active := true; active; {
+			select {
+			case <-app.ctx.Done():
+			case <-ticker.C:
+			}
+			// the UI may not run queued updates anymore once the app
+			// quits, so the update would block forever
+			if app.ctx.Err() != nil {
+				ticker.Stop()
+				return
+			}
+			app.app.QueueUpdateDraw(func() {
+				for _, e := range app.effects.Expire(time.Now()) {
+					info := fmt.Sprintf("%s has ended", e.Name)
+					app.eventReg.Register(EventInfo, info, info)
+				}
+				app.updateEffects()
+				active = len(app.effects.Effects) > 0
+			})
+		}
+		ticker.Stop()
+	}
+}
+
+// Wakes tickEffects up after an effect is activated
+func (app *App) wakeEffects() {
+	select {
+	case app.effectsChan <- true:
+	default:
+		// it is already awake or will be
+	}
+}
+
 // Waits for the data in the data channel. Stays always open
 func (app *App) waitForData() {
 	for v := range app.dataChan {
-		app.spells = &v
-		app.updateSpellList()
+		load := v
 		// update the data lock
-		app.fetchLock = false
-		// for some reason, the screen isn't auto updated on the initial spell set
-		// so it has to be so manually.
-		app.app.Draw()
+		app.endFetch()
+		// the loaded data is used by the UI, so it is set in the main tview
+		// goroutine. The screen is redrawn afterwards, it isn't auto updated
+		// on the initial spell set.
+		app.app.QueueUpdateDraw(func() {
+			app.setLoad(load)
+		})
+		go app.revalidateCaches(load.fetchers, load.config.CacheMaxAge())
+	}
+}
+
+// Replaces the spells and the config with those that were loaded
+func (app *App) setLoad(load *spellLoad) {
+	if load.configChanges == app.configChanges {
+		app.config = load.config
+		app.configErr = load.configErr
+	}
+	app.diagnostics = load.diagnostics
+	app.spells = &load.spells
+	app.index = NewSearchIndex(load.spells)
+	if app.fullText {
+		app.search(app.inputText)
+	} else {
+		app.updateSpellList()
 	}
 }
 
@@ -127,18 +272,33 @@ func (app *App) waitForStatuses() {
 	}
 }
 
+// Shows the progress of fetches in the status box. Progress that arrives
+// after the fetch has ended is ignored.
+func (app *App) waitForProgress() {
+	for p := range app.progressChan {
+		if app.fetching() {
+			app.setStatus(p.Gauge(progressGaugeWidth))
+		}
+	}
+}
+
 // The main app global input handler
 func (app *App) handleInput(event *tcell.EventKey) *tcell.EventKey {
 	// if status exists, clear it, but only if data is not being fetched atm
-	if app.Status() != "" && !app.fetchLock {
+	if app.Status() != "" && !app.fetching() {
 		app.setStatus("")
 	}
 	//fmt.Print(event)
 	switch event.Key() {
 	case tcell.KeyEnter:
+		if app.InputMode() == InputCommand {
+			app.execCommand(app.input.GetText())
+			app.setInputMode(InputNormal)
+			break
+		}
 		//ui.wideboxFakeFocus = true
 		if spell := app.currentSelectedSpell(); spell != nil {
-			app.widebox.SetSpell(spell)
+			app.showSpell(spell)
 		}
 	case tcell.KeyUp, tcell.KeyCtrlK:
 		if app.wideboxFakeFocus {
@@ -173,7 +333,12 @@ func (app *App) handleInput(event *tcell.EventKey) *tcell.EventKey {
 	case tcell.KeyTab:
 		app.switchFocus()
 	case tcell.KeyESC:
-		app.setInputMode(InputNormal)
+		// leaves the command mode first, cancels loading of spells after
+		if app.InputMode() == InputCommand {
+			app.setInputMode(InputNormal)
+		} else if app.CancelFetch() {
+			app.setStatus("Cancelling...")
+		}
 	case tcell.KeyCtrlN:
 		app.setInputMode(InputCommand)
 	// this will run before its default behavior (closing the application)
@@ -196,9 +361,13 @@ func (app *App) setInputMode(mode InputMode) error {
 	switch mode {
 	case InputNormal:
 		app.input.SetLabel("> ")
+		// bring back the filter text that was there before the command mode
+		app.input.SetText(app.inputText)
 		return nil
 	case InputCommand:
 		app.input.SetLabel(": ")
+		// the filter is kept in app.inputText so the field can be cleared
+		app.input.SetText("")
 		return nil
 	}
 	// by this point, if the mode was valid, the function would return, that
@@ -219,6 +388,37 @@ func (app *App) setStatus(text string) {
 	app.statusBox.SetText(text)
 }
 
+// Shows the spell in the widebox
+func (app *App) showSpell(s *Spell) {
+	app.shownSpell = s
+	app.widebox.SetSpell(s)
+	app.widebox.SetCastable(app.slots.castStatus(s))
+}
+
+// Shows the text in the widebox instead of a spell
+func (app *App) showInfo(title, text string) {
+	app.shownSpell = nil
+	app.widebox.SetInfo(title, text)
+}
+
+// Updates the slot panel and whether the shown spell can be cast. Should be
+// called every time slots change.
+func (app *App) updateSlots() {
+	if app.slots == nil {
+		app.slotbox.SetText("No character selected\n\n:char <name>")
+		return
+	}
+	app.slotbox.SetText(app.slots.String())
+	if app.shownSpell != nil {
+		app.widebox.SetCastable(app.slots.castStatus(app.shownSpell))
+	}
+}
+
+// Updates the effect panel
+func (app *App) updateEffects() {
+	app.effectbox.SetText(app.effects.Render(time.Now()))
+}
+
 // Switches focus between the list on the left and the main content area to the right
 func (app *App) switchFocus() {
 	if app.wideboxFakeFocus {
@@ -247,98 +447,242 @@ func (app *App) focusWideBox() {
 func (app *App) updateSpellList() *[]string {
 	var items []string
 	app.list.Clear()
-	for i, s := range *app.spells {
-		lname := strings.ToLower(s.Name)
-		linput := strings.ToLower(app.inputText)
-
-		if !strings.Contains(lname, linput) {
-			continue
+	// snippets of full text matches are shown under the spell names
+	app.list.ShowSecondaryText(app.fullText)
+	snippets := map[int]string{}
+	for _, hit := range app.searchHits {
+		snippets[hit.Doc] = hit.Snippet
+	}
+	spells := *app.spells
+	app.listed = app.filterSpells()
+	for _, i := range app.listed {
+		s := spells[i]
+		prefix := strconv.Itoa(s.Level) + " "
+		nameString := prefix + s.Name
+		var suffix string
+
+		var flags string
+		if s.Concentration {
+			flags += "C"
+		}
+		if s.Ritual {
+			flags += "R"
+		}
+		// spells in the shown book are marked as prepared or known
+		if book := app.books.Get(app.bookFilter); book != nil {
+			if entry := book.Entry(s.Index); entry != nil && entry.Prepared {
+				flags += "P"
+			} else if entry != nil && entry.Known {
+				flags += "K"
+			}
 		}
-		nameString := strconv.Itoa(s.Level) + " " + s.Name
 
-		if s.Ritual || s.Concentration {
+		if flags != "" {
 			_, _, w, _ := app.list.Box.GetInnerRect()
 			padLen := w - len(nameString)
-			padNum := 0
-			if s.Concentration {
-				padNum++
-			}
-			if s.Ritual {
-				padNum++
-			}
-
-			if padLen >= 3 {
-				nameString += strings.Repeat(" ", padLen-padNum)
-				if s.Concentration {
-					nameString += "C"
-				}
-				if s.Ritual {
-					nameString += "R"
-				}
+			if padLen >= 3 && padLen > len(flags) {
+				suffix = strings.Repeat(" ", padLen-len(flags)) + flags
 			}
 		}
 
-		hlght := highlight(nameString, app.inputText)
+		// only the name is highlighted since only it is matched by free text
+		hlght := prefix + s.Name + suffix
+		if !app.fullText {
+			hlght = prefix + highlightMask(s.Name, app.query.HighlightMask(s.Name)) + suffix
+		}
 		items = append(items, hlght)
-		app.list.AddItem(hlght, strconv.Itoa(i), 0, nil)
+		app.list.AddItem(hlght, snippets[i], 0, nil)
+	}
+	// title shows how many spells are shown out of total
+	title := fmt.Sprintf("%d/%d", len(items), len(*app.spells))
+	if book := app.books.Get(app.bookFilter); book != nil {
+		title = book.Name + " " + title
 	}
-    // title shows how many spells are shown out of total
-    app.list.SetTitle(fmt.Sprintf("%d/%d", len(items), len(*app.spells)))
+	app.list.SetTitle(title)
 	return &items
 }
 
+// Returns indices of spells in app.spells that pass the input text and the
+// filters set by commands, in the order they should be shown
+func (app *App) filterSpells() []int {
+	var indices []int
+	spells := *app.spells
+	if app.fullText {
+		// hits are already ordered by relevance
+		for _, hit := range app.searchHits {
+			if app.passesFilters(&spells[hit.Doc]) {
+				indices = append(indices, hit.Doc)
+			}
+		}
+	} else {
+		for i := range spells {
+			if app.query.Match(&spells[i]) && app.passesFilters(&spells[i]) {
+				indices = append(indices, i)
+			}
+		}
+	}
+	if less, ok := spellSorters[app.sortBy]; ok {
+		sort.SliceStable(indices, func(i, j int) bool {
+			return less(spells, indices[i], indices[j])
+		})
+		return indices
+	}
+	// if no sorting was set, the best matches come first
+	if !app.fullText && app.query != nil && len(app.query.Terms) > 0 {
+		scores := make(map[int]int, len(indices))
+		for _, i := range indices {
+			scores[i] = app.query.Score(&spells[i])
+		}
+		sort.SliceStable(indices, func(i, j int) bool {
+			return scores[indices[i]] > scores[indices[j]]
+		})
+	}
+	return indices
+}
+
+// Reports whether the spell passes the filters set by commands
+func (app *App) passesFilters(s *Spell) bool {
+	if app.levelFilter >= 0 && s.Level != app.levelFilter {
+		return false
+	}
+	if app.classFilter != "" && !s.HasClass(app.classFilter) {
+		return false
+	}
+	if app.bookFilter != "" {
+		if book := app.books.Get(app.bookFilter); book == nil || book.Entry(s.Index) == nil {
+			return false
+		}
+	}
+	if app.config != nil && !app.config.DocumentEnabled(s.Document.Slug) {
+		return false
+	}
+	return true
+}
+
+// documentInfo describes a source document of the loaded spells
+type documentInfo struct {
+	Slug, Title string
+	// number of spells from the document
+	Count int
+}
+
+// Returns documents of all loaded spells, ordered by slug. Spells without a
+// document are left out.
+func (app *App) documents() []documentInfo {
+	bySlug := map[string]*documentInfo{}
+	var docs []*documentInfo
+	for _, s := range *app.spells {
+		slug := s.Document.Slug
+		if slug == "" {
+			continue
+		}
+		d, ok := bySlug[slug]
+		if !ok {
+			d = &documentInfo{Slug: slug}
+			bySlug[slug] = d
+			docs = append(docs, d)
+		}
+		if d.Title == "" {
+			d.Title = s.Document.Title
+		}
+		d.Count++
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Slug < docs[j].Slug })
+	infos := make([]documentInfo, len(docs))
+	for i, d := range docs {
+		infos[i] = *d
+	}
+	return infos
+}
+
 // Returns the current selected spell. Returns nil if there are no spells in the list
-func (app App) currentSelectedSpell() *Spell {
+func (app *App) currentSelectedSpell() *Spell {
 	if app.list.GetItemCount() < 1 {
 		return nil
 	}
-	_, s := app.list.GetItemText(app.list.GetCurrentItem())
-	index, err := strconv.Atoi(s)
-	if err != nil {
-		panic(err)
-	}
 	spells := *app.spells
-	return &spells[index]
+	return &spells[app.listed[app.list.GetCurrentItem()]]
 }
 
 // Handler than should be ran on every text input change. Filters the spell list
 // on text update.
 func (app *App) setInputText(text string) {
+	// in command mode the text is a command that is ran on Enter
+	if app.InputMode() == InputCommand {
+		return
+	}
 	// focus the list on key input if the main content box happens to be focused atm
-	// TODO: change behavior on different modes (normal, command...)
 	app.focusList()
 	app.inputText = text
+	if strings.HasPrefix(text, "/") {
+		app.search(text)
+		return
+	}
+	app.fullText = false
+	app.searchHits = nil
+	query, err := ParseQuery(text)
+	if err != nil {
+		// the query is most likely being typed atm, so the last valid query
+		// is kept until this one becomes valid
+		app.setStatus("Invalid query: " + err.Error())
+		return
+	}
+	app.query = query
 	app.updateSpellList()
 }
 
-// Highlight a substring in a string regardless of it's capitalisation.
-// May not work properly with unicode
-func highlight(str, substr string) string {
-	if substr == "" {
-		return str
+// Runs a full text search over spell descriptions. The text must start with "/".
+func (app *App) search(text string) {
+	hits, err := app.index.Search(strings.TrimPrefix(text, "/"))
+	if err != nil {
+		app.setStatus("Invalid search: " + err.Error())
+		return
 	}
-	lname := strings.ToLower(str)
-	linput := strings.ToLower(substr)
-	parts := strings.Split(lname, linput)
-
-	// precalculated lengths of color prefixes for a small performance gain
-	prelen := len(HlghtSubstr)
-	postlen := len(HlghtNormal)
-	patternlen := len(substr)
+	app.fullText = true
+	app.searchHits = hits
+	app.updateSpellList()
+}
 
-	var final string
-	for i, w := range parts {
-		startx := len(final)
-		if i > 1 {
-			startx -= (i - 1) * (prelen + postlen)
+// Marks bytes of all occurences of term in str regardless of capitalisation
+func markTerm(str, term string, mask []bool) {
+	lstr := strings.ToLower(str)
+	lterm := strings.ToLower(term)
+	// lowercasing some unicode characters changes their byte length which
+	// would make the indices below invalid
+	if lterm == "" || len(lstr) != len(str) {
+		return
+	}
+	for start := 0; ; {
+		i := strings.Index(lstr[start:], lterm)
+		if i < 0 {
+			break
 		}
-		if i != 0 {
-			final += HlghtSubstr + str[startx:startx+patternlen] + HlghtNormal
-			startx += patternlen
+		for j := start + i; j < start+i+len(lterm); j++ {
+			mask[j] = true
 		}
-		final += str[startx : startx+len(w)]
+		start += i + len(lterm)
+	}
+}
+
+// Wraps bytes of str for which mask is true in highlight color tags
+func highlightMask(str string, mask []bool) string {
+	var final strings.Builder
+	var on bool
+	for i := 0; i < len(str); i++ {
+		if mask[i] != on {
+			on = mask[i]
+			if on {
+				final.WriteString(HlghtSubstr)
+			} else {
+				final.WriteString(HlghtNormal)
+			}
+		}
+		final.WriteByte(str[i])
+	}
+	if on {
+		final.WriteString(HlghtNormal)
 	}
-	return final
+	return final.String()
 }
 
 // Returns a pointer to a new list element preconfigured for the app
@@ -359,6 +703,20 @@ func getInputField(processInput func(string)) *tview.InputField {
 	return input
 }
 
+// Returns a pointer to a new spell slot panel
+func getSlotBox() *tview.TextView {
+	box := tview.NewTextView().SetDynamicColors(true)
+	box.SetBorder(true).SetTitle("Slots")
+	return box
+}
+
+// Returns a pointer to a new active effect panel
+func getEffectBox() *tview.TextView {
+	box := tview.NewTextView().SetDynamicColors(true)
+	box.SetBorder(true).SetTitle("Active")
+	return box
+}
+
 func getStatusBox() *tview.TextView {
 	box := tview.NewTextView().SetTextAlign(tview.AlignRight)
 	box.SetBorder(false)
@@ -1,15 +1,58 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/rivo/tview"
 )
 
-var ExampleSpells = Spells{{Index: "acid-arrow", Name: "Acid Arrow", Desc: "Green arrow", HigherLevel: "", Range: "90 feet", Components: []string{"V", "S", "M"}, Material: "", Ritual: false, Duration: "", Concentration: false, CastingTime: "1 action", Level: 2, School: struct{ Name string }{Name: "Evocation"}, Classes: []struct{ Name string }{{Name: "Druid"}, {Name: "Wizard"}}, Subclasses: []struct{ Name string }{{Name: "Druid (Swamp)"}}}, {Index: "acid-splash", Name: "Acid Splash", Desc: "Bubble", HigherLevel: "", Range: "60 feet", Components: []string{"V", "S"}, Material: "", Ritual: false, Duration: "Instantaneous", Concentration: false, CastingTime: "1 action", Level: 0, School: struct{ Name string }{Name: "Conjuration"}, Classes: []struct{ Name string }{{Name: "Sorcerer"}, {Name: "Wizard"}}, Subclasses: []struct{ Name string }(nil)}, Spell{Index: "cone-of-cold", Name: "Cone of Cold", Desc: "Blast of air", HigherLevel: "1d8", Range: "", Components: []string{"V", "S", "M"}, Material: "A small crystal or glass cone.", Ritual: false, Duration: "Instantaneous", Concentration: false, CastingTime: "1 action", Level: 5, School: struct{ Name string }{Name: "Evocation"}, Classes: []struct{ Name string }{{Name: "Druid"}, {Name: "Sorcerer"}, {Name: "Wizard"}}, Subclasses: []struct{ Name string }(nil)}, Spell{Index: "confusion", Name: "Confusion", Desc: "Twists minds", HigherLevel: "5 feet", Range: "", Components: []string(nil), Material: "Three walnut shells.", Ritual: false, Duration: "", Concentration: true, CastingTime: "1 action", Level: 4, School: struct{ Name string }{Name: ""}, Classes: []struct{ Name string }{{Name: "Bard"}, {Name: "Druid"}}, Subclasses: []struct{ Name string }{{Name: "Cleric (Knowledge)"}}}}
+var ExampleSpells = Spells{{Index: "acid-arrow", Name: "Acid Arrow", Desc: "Green arrow", HigherLevel: "", Range: "90 feet", Components: []string{"V", "S", "M"}, Material: "", Ritual: false, Duration: "", Concentration: false, CastingTime: "1 action", Level: 2, School: struct{ Name string }{Name: "Evocation"}, Classes: []struct{ Name string }{{Name: "Druid"}, {Name: "Wizard"}}, Subclasses: []struct{ Name string }{{Name: "Druid (Swamp)"}}, Document: Document{Slug: "wotc-srd"}, Page: "phb 259", LevelName: "2nd-level", SpellLists: []string{"druid", "wizard"}, RangeSort: 90}, {Index: "acid-splash", Name: "Acid Splash", Desc: "Bubble", HigherLevel: "", Range: "60 feet", Components: []string{"V", "S"}, Material: "", Ritual: false, Duration: "Instantaneous", Concentration: false, CastingTime: "1 action", Level: 0, School: struct{ Name string }{Name: "Conjuration"}, Classes: []struct{ Name string }{{Name: "Sorcerer"}, {Name: "Wizard"}}, Subclasses: []struct{ Name string }(nil), Document: Document{Title: "Systems Reference Document", LicenseURL: "http://aaheee.com/ssdgi"}, Page: "phb 211", LevelName: "Cantrip"}, Spell{Index: "cone-of-cold", Name: "Cone of Cold", Desc: "Blast of air", HigherLevel: "1d8", Range: "", Components: []string{"V", "S", "M"}, Material: "A small crystal or glass cone.", Ritual: false, Duration: "Instantaneous", Concentration: false, CastingTime: "1 action", Level: 5, School: struct{ Name string }{Name: "Evocation"}, Classes: []struct{ Name string }{{Name: "Druid"}, {Name: "Sorcerer"}, {Name: "Wizard"}}, Subclasses: []struct{ Name string }(nil), Document: Document{Slug: "wotc-srd", LicenseURL: "http://eeee.com/aa"}, LevelName: "5th-level"}, Spell{Index: "confusion", Name: "Confusion", Desc: "Twists minds", HigherLevel: "5 feet", Range: "", Components: []string(nil), Material: "Three walnut shells.", Ritual: false, Duration: "", Concentration: true, CastingTime: "1 action", Level: 4, School: struct{ Name string }{Name: ""}, Classes: []struct{ Name string }{{Name: "Bard"}, {Name: "Druid"}}, Subclasses: []struct{ Name string }{{Name: "Cleric (Knowledge)"}}, Document: Document{Slug: "wotc-srd"}, Page: "phb 224", LevelName: "4th-level", SpellLists: []string{"bard", "druid"}, RangeSort: 90, Extra: map[string]json.RawMessage{"v2_converted_path": json.RawMessage(`"/v2/spells/srd_confusion"`)}}}
+
+var AppTest *App
+
+// Tests share an app whose files are in a temporary dir and which can't
+// reach any API, so running them doesn't touch the config of whoever runs them
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "litch")
+	if err != nil {
+		panic(err)
+	}
+	AppTest = newApp(testEnv(dir, offlinePages))
+	code := m.Run()
+	AppTest.Quit()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// Returns an env with all files in dir whose API pages are fetched with pages
+func testEnv(dir string, pages *PageFetcher) *Env {
+	return &Env{
+		ConfigFile:   dir + "/config.json",
+		CacheDir:     dir + "/cache",
+		LocalDir:     dir + "/local",
+		SpellbookDir: dir + "/spellbooks",
+		SlotDir:      dir + "/slots",
+		ExportDir:    dir + "/exports",
+		LogFile:      dir + "/log.txt",
+		Pages:        pages,
+	}
+}
 
-var AppTest = newApp()
+// Fails all requests, so that tests never reach the real APIs
+var offlinePages = &PageFetcher{
+	Get: func(ctx context.Context, url string, cond Validators) (*apiPage, error) {
+		return nil, fmt.Errorf("GET %s: no network in tests", url)
+	},
+	TotalTimeout: time.Minute,
+}
 
 func TestStatus(t *testing.T) {
 	var tests = []struct {
@@ -63,8 +106,11 @@ func TestSetSpells(t *testing.T) {
 		// 2 Acid Arrow, 0 Acid Splash, 5 Cone of Cold, 4 Confusion
 		{&ExampleSpells, "Splash", []string{fmt.Sprintf("0 Acid %sSplash%s", HlghtSubstr, HlghtNormal)}},
 		{&ExampleSpells, "Acid", []string{fmt.Sprintf("2 %sAcid%s Arrow", HlghtSubstr, HlghtNormal), fmt.Sprintf("0 %sAcid%s Splash", HlghtSubstr, HlghtNormal)}},
-		{&ExampleSpells, "co", []string{fmt.Sprintf("5 %sCo%sne of %sCo%sld", HlghtSubstr, HlghtNormal, HlghtSubstr, HlghtNormal), fmt.Sprintf("4 %sCo%snfusion", HlghtSubstr, HlghtNormal)}},
-		{&ExampleSpells, "Coon", []string(nil)},
+		// prefix matches come first, fuzzy matches highlight only the matched runes
+		{&ExampleSpells, "co", []string{fmt.Sprintf("5 %sCo%sne of %sCo%sld", HlghtSubstr, HlghtNormal, HlghtSubstr, HlghtNormal), fmt.Sprintf("4 %sCo%snfusion", HlghtSubstr, HlghtNormal), fmt.Sprintf("2 A%sc%sid Arr%so%sw", HlghtSubstr, HlghtNormal, HlghtSubstr, HlghtNormal)}},
+		{&ExampleSpells, "Coon", []string{fmt.Sprintf("4 %sCo%snfusi%son%s", HlghtSubstr, HlghtNormal, HlghtSubstr, HlghtNormal)}},
+		{&ExampleSpells, "cold", []string{fmt.Sprintf("5 Cone of %sCold%s", HlghtSubstr, HlghtNormal)}},
+		{&ExampleSpells, "Coonx", []string(nil)},
 	}
 
 	for _, test := range tests {
@@ -78,27 +124,20 @@ func TestSetSpells(t *testing.T) {
 	}
 }
 
-func TestHighlight(t *testing.T) {
-	var tests = []struct {
-		str    string
-		substr string
-		want   string
-	}{
-		{"Aura of Light", "Light", fmt.Sprintf("Aura of %sLight%s", HlghtSubstr, HlghtNormal)},
-		{"Light of Aura", "Light ", fmt.Sprintf("%sLight %sof Aura", HlghtSubstr, HlghtNormal)},
-		{"Testing of Testing Test", "Meow", "Testing of Testing Test"},
-		{"Aura of Aura", "Aura", fmt.Sprintf("%sAura%s of %sAura%s", HlghtSubstr, HlghtNormal, HlghtSubstr, HlghtNormal)},
-		{"Dog Meo Dog", "Meow", "Dog Meo Dog"},
-		{"Work Meo work", "Meow", "Work Meo work"},
-		{"Seagull is a boring animal", "Boring", fmt.Sprintf("Seagull is a %sboring%s animal", HlghtSubstr, HlghtNormal)},
-		{"Seagull is a boring animal", "BoRiNg", fmt.Sprintf("Seagull is a %sboring%s animal", HlghtSubstr, HlghtNormal)},
-		{"Woof", "", "Woof"},
-	}
-	for _, test := range tests {
-		output := highlight(test.str, test.substr)
-		//t.Log(output)
-		if output != test.want {
-			t.Errorf("Unexpected result, expected \"%s\", but got \"%s\"", test.want, output)
-		}
+func TestTickEffectsStops(t *testing.T) {
+	// the UI of the app never runs, so queued updates would block
+	app := &App{app: tview.NewApplication(), effectsChan: make(chan bool, 1)}
+	app.ctx, app.cancel = context.WithCancel(context.Background())
+	stopped := make(chan bool)
+	go func() {
+		app.tickEffects()
+		close(stopped)
+	}()
+	app.wakeEffects()
+	app.cancel()
+	select {
+	case <-stopped:
+	case <-time.After(3 * time.Second):
+		t.Errorf("The countdown of effects didn't stop after quitting")
 	}
 }
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"time"
+)
+
+// Version of the cache format. It must be bumped every time the format
+// changes in a way older versions of litch can't read.
+const CacheSchemaVersion = 1
+
+// Age of caches whose fetch time is unknown
+const unknownAge = time.Duration(math.MaxInt64)
+
+// CacheEnvelope is what is saved in a cache file. Besides the spells, it
+// records where and when they were fetched from, so that the cache can be
+// checked for staleness.
+type CacheEnvelope struct {
+	Version int       `json:"version"`
+	Fetched time.Time `json:"fetched"`
+	Source  string    `json:"source"`
+	Validators
+	// hex SHA-256 of the encoded spells, empty in caches written before it
+	// existed
+	Checksum string `json:"checksum,omitempty"`
+	Spells   Spells `json:"spells"`
+	// key of cacheFormats in which the cache was loaded
+	format string
+}
+
+// ErrCorruptCache is wrapped by errors of caches whose spells don't match
+// their checksum, per example because writing them was interrupted
+var ErrCorruptCache = errors.New("cache is corrupt")
+
+// Loads a cache from the file in any of cacheFormats. Caches written before
+// the envelope existed are bare arrays of spells, they are loaded with an
+// unknown fetch time.
+func loadCache(file string) (*CacheEnvelope, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	format := detectCacheFormat(data)
+	cache, err := cacheFormats[format].decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if cache.Version > CacheSchemaVersion {
+		return nil, fmt.Errorf("cache version %d is newer than the supported %d", cache.Version, CacheSchemaVersion)
+	}
+	cache.format = format
+	return cache, nil
+}
+
+// Loads a cache from the file, or from its backup if the file is missing or
+// corrupt. usedBackup tells whether the backup was loaded.
+func loadCacheOrBackup(file string) (cache *CacheEnvelope, usedBackup bool, err error) {
+	cache, err = loadCache(file)
+	if err == nil {
+		return cache, false, nil
+	}
+	if backup, bakErr := loadCache(backupPath(file)); bakErr == nil {
+		return backup, true, nil
+	}
+	return nil, false, err
+}
+
+// Writes the cache to the file. It is written to a temporary file first
+// which then replaces the file, so an interrupted write never leaves a
+// truncated cache behind. The replaced cache is kept as a backup if it looks
+// complete. Format is a key of cacheFormats, "" defaults to JSON.
+func writeCache(file string, cache *CacheEnvelope, format string) error {
+	codec, ok := cacheFormats[format]
+	if !ok {
+		format, codec = "json", cacheFormats["json"]
+	}
+	cache.Version = CacheSchemaVersion
+	data, err := codec.encode(cache)
+	if err != nil {
+		return err
+	}
+	if err := readyDir(path.Dir(file)); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(path.Dir(file), "."+path.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// does nothing once the file is renamed
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// temporary files are created only readable by the owner
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	if cacheLooksComplete(file) {
+		if err := os.Rename(file, backupPath(file)); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return err
+	}
+	syncDir(path.Dir(file))
+	cache.format = format
+	return nil
+}
+
+// Reports whether the file starts like a cache and, for JSON, also ends like
+// one. Only the ends of the file are read, decoding the whole cache to check
+// it would double the work of every write.
+func cacheLooksComplete(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	const n = 16
+	head := make([]byte, n)
+	if _, err := io.ReadFull(f, head); err != nil && err != io.ErrUnexpectedEOF {
+		return false
+	}
+	switch detectCacheFormat(head) {
+	case "gzip", "gob":
+		return info.Size() > n
+	}
+	tail := make([]byte, n)
+	start := info.Size() - n
+	if start < 0 {
+		start = 0
+	}
+	read, err := f.ReadAt(tail, start)
+	if err != nil && err != io.EOF {
+		return false
+	}
+	head, tail = bytes.TrimSpace(head), bytes.TrimSpace(tail[:read])
+	if len(head) == 0 || len(tail) == 0 {
+		return false
+	}
+	first, last := head[0], tail[len(tail)-1]
+	return first == '{' && last == '}' || first == '[' && last == ']'
+}
+
+// Returns the path of the backup of a cache
+func backupPath(file string) string {
+	return file + ".bak"
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Flushes renames in the dir to the disk. Not all platforms support syncing
+// dirs, so errors are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// Returns how long ago spells were fetched at the fetch time. Caches with an
+// unknown fetch time are considered infinitely old.
+func cacheAge(fetched, now time.Time) time.Duration {
+	if fetched.IsZero() {
+		return unknownAge
+	}
+	return now.Sub(fetched)
+}
+
+// Formats the age of a cache for statuses
+func formatAge(age time.Duration) string {
+	days := int(age / (24 * time.Hour))
+	switch {
+	case age == unknownAge:
+		return "of unknown age"
+	case days == 0:
+		return "less than a day old"
+	case days == 1:
+		return "1 day old"
+	}
+	return fmt.Sprintf("%d days old", days)
+}
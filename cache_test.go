@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadCache(t *testing.T) {
+	var tests = []struct {
+		cache   string
+		want    []string
+		fetched time.Time
+		etag    string
+		wantErr bool
+	}{
+		{`{
+			"version": 1,
+			"fetched": "2026-01-02T03:04:05Z",
+			"source": "https://api.open5e.com/spells/",
+			"etag": "\"v1\"",
+			"spells": [{"index": "fog"}, {"index": "wish"}]
+		}`, []string{"fog", "wish"}, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), "\"v1\"", false},
+		// caches from before the envelope are bare arrays of unknown age
+		{`[{"index": "fog"}]`, []string{"fog"}, time.Time{}, "", false},
+		{`{"version": 2, "spells": []}`, nil, time.Time{}, "", true},
+		{`{"version": 1, "spells": [`, nil, time.Time{}, "", true},
+	}
+
+	for _, test := range tests {
+		file := t.TempDir() + "/spells.json"
+		if err := ioutil.WriteFile(file, []byte(test.cache), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cache, err := loadCache(file)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Unexpected error for cache %s: %v", test.cache, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var output []string
+		for _, s := range cache.Spells {
+			output = append(output, s.Index)
+		}
+		if !reflect.DeepEqual(output, test.want) {
+			t.Errorf("Unexpected spells.\nhave: \"%v\"\nwant: \"%v\"", output, test.want)
+		}
+		if !cache.Fetched.Equal(test.fetched) || cache.ETag != test.etag {
+			t.Errorf("Unexpected metadata: %v, %s", cache.Fetched, cache.ETag)
+		}
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	now := time.Now()
+	var tests = []struct {
+		fetched time.Time
+		want    string
+	}{
+		{now.Add(-time.Hour), "less than a day old"},
+		{now.Add(-30 * time.Hour), "1 day old"},
+		{now.Add(-10 * 24 * time.Hour), "10 days old"},
+		{time.Time{}, "of unknown age"},
+	}
+	for _, test := range tests {
+		if output := formatAge(cacheAge(test.fetched, now)); output != test.want {
+			t.Errorf("Unexpected result, expected \"%s\", but got \"%s\"", test.want, output)
+		}
+	}
+}
+
+func TestRevalidateCache(t *testing.T) {
+	pages := NewPageFetcher(NewAPIClient())
+
+	etag := `"v1"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(`{"count": 1, "next": null, "results": [{"slug": "fog", "name": "Fog"}]}`))
+	}))
+	defer server.Close()
+
+	file := t.TempDir() + "/spells.json"
+	f := NewSpellFetcher("remote spells", file, server.URL, "open5e", pages, NewEventRegister(AppTest.eventReg.logger, nil, nil))
+	validators, err := f.format.fetch(context.Background(), f.pages, server.URL, f.data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validators.ETag != etag {
+		t.Fatalf("Expected ETag %s, but got %s", etag, validators.ETag)
+	}
+
+	// pretend that the spells were loaded from an old cache
+	old := time.Now().Add(-10 * 24 * time.Hour)
+	f.fetched, f.validators, f.fromCache = old, validators, true
+	maxAge := 7 * 24 * time.Hour
+	if !f.Stale(maxAge, time.Now()) {
+		t.Errorf("Expected the cache to be stale")
+	}
+	if f.Stale(-1, time.Now()) {
+		t.Errorf("Expected negative max age to disable the check")
+	}
+
+	changed, err := f.Revalidate(context.Background())
+	if err != nil || changed {
+		t.Fatalf("Expected unchanged spells, got %v, %v", changed, err)
+	}
+	if f.Stale(maxAge, time.Now()) {
+		t.Errorf("Expected the cache to be renewed")
+	}
+	cache, err := loadCache(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cache.Fetched.After(old) || cache.Source != server.URL || cache.ETag != etag || len(cache.Spells) != 1 {
+		t.Errorf("Unexpected renewed cache: %#v", cache)
+	}
+
+	etag = `"v2"`
+	if changed, err := f.Revalidate(context.Background()); err != nil || !changed {
+		t.Errorf("Expected changed spells, got %v, %v", changed, err)
+	}
+}
+
+func TestFailedRefetchKeepsCache(t *testing.T) {
+	pages := NewPageFetcher(newTestClient())
+
+	var tests = []struct {
+		name     string
+		status   int
+		body     string
+		noSpells bool
+	}{
+		{"server error", 503, ``, false},
+		// the second page can't be fetched
+		{"partial fetch", 200, `{"count": 2, "next": "http://127.0.0.1:1/spells/?page=2", "results": [{"slug": "wish"}]}`, false},
+		{"no spells", 200, `{"count": 0, "next": null, "results": []}`, true},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+		file := t.TempDir() + "/spells.json"
+		cache := `{"version": 1, "source": "` + server.URL + `", "spells": [{"index": "fog"}]}`
+		if err := ioutil.WriteFile(file, []byte(cache), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		f := NewSpellFetcher("remote spells", file, server.URL, "open5e", pages, NewEventRegister(AppTest.eventReg.logger, nil, nil))
+		ch := make(chan Spells, 1)
+		f.FetchSpells(context.Background(), ch, true)
+		server.Close()
+
+		if spells := <-ch; len(spells) != 1 || spells[0].Index != "fog" {
+			t.Errorf("%s: expected the cached spells, but got %v", test.name, spells)
+		}
+		if f.err == nil {
+			t.Errorf("%s: expected the fetch to fail", test.name)
+		}
+		if test.noSpells && f.err != errNoSpells {
+			t.Errorf("%s: expected %v, but got %v", test.name, errNoSpells, f.err)
+		}
+		if data, _ := ioutil.ReadFile(file); string(data) != cache {
+			t.Errorf("%s: expected the cache to stay untouched, but got %s", test.name, data)
+		}
+	}
+}
+
+func TestWriteCache(t *testing.T) {
+	dir := t.TempDir()
+	file := dir + "/spells.json"
+
+	first := CacheEnvelope{Source: "first", Spells: Spells{ExampleSpells[0]}}
+	if err := writeCache(file, &first, "json"); err != nil {
+		t.Fatal(err)
+	}
+	if first.Checksum == "" || first.Version != CacheSchemaVersion {
+		t.Errorf("Expected the checksum and the version to be set, but got %#v", first)
+	}
+	if checkFile(backupPath(file)) {
+		t.Errorf("Expected no backup of a new cache")
+	}
+	second := CacheEnvelope{Source: "second", Spells: ExampleSpells}
+	if err := writeCache(file, &second, "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, usedBackup, err := loadCacheOrBackup(file)
+	if err != nil || usedBackup {
+		t.Fatalf("Expected the cache to load, got %v, %v", usedBackup, err)
+	}
+	if cache.Source != "second" || !reflect.DeepEqual(cache.Spells, ExampleSpells) {
+		t.Errorf("Unexpected cache: %#v", cache)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 2 {
+		t.Errorf("Expected only the cache and its backup, but got %d files", len(files))
+	}
+
+	// a write that was cut off
+	data, _ := ioutil.ReadFile(file)
+	if err := ioutil.WriteFile(file, data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCache(file); !errors.Is(err, ErrCorruptCache) {
+		t.Errorf("Expected a truncated cache to be corrupt, but got %v", err)
+	}
+	cache, usedBackup, err = loadCacheOrBackup(file)
+	if err != nil || !usedBackup || cache.Source != "first" {
+		t.Errorf("Expected the backup to load, got %v, %v", usedBackup, err)
+	}
+	// the corrupt cache must not replace the valid backup
+	if err := writeCache(file, &second, "json"); err != nil {
+		t.Fatal(err)
+	}
+	if backup, err := loadCache(backupPath(file)); err != nil || backup.Source != "first" {
+		t.Errorf("Expected the backup to be kept, got %v", err)
+	}
+
+	// spells that don't match the checksum
+	tampered := bytes.Replace(data, []byte("Acid Arrow"), []byte("Acid Arrov"), 1)
+	if err := ioutil.WriteFile(file, tampered, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCache(file); !errors.Is(err, ErrCorruptCache) {
+		t.Errorf("Expected a tampered cache to be corrupt, but got %v", err)
+	}
+}
+
+func TestCacheLooksComplete(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		data     string
+		complete bool
+	}{
+		{`{"spells": []}`, true},
+		{"  [1, 2]\n", true},
+		{`{"spells": [{"name": "Fo`, false},
+		{`{"spells": []]`, false},
+		{"", false},
+		{"   ", false},
+		{string(gobMagic) + "some encoded spells", true},
+		{"\x1f\x8bsome compressed spells", true},
+		{"\x1f\x8b", false},
+	}
+	for i, test := range tests {
+		file := fmt.Sprintf("%s/%d.json", dir, i)
+		if err := ioutil.WriteFile(file, []byte(test.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := cacheLooksComplete(file); got != test.complete {
+			t.Errorf("Expected %v for %q, but got %v", test.complete, test.data, got)
+		}
+	}
+	if cacheLooksComplete(dir + "/missing.json") {
+		t.Errorf("Expected a missing cache not to look complete")
+	}
+}
+
+func TestCorruptCacheIsRefetched(t *testing.T) {
+	pages := NewPageFetcher(NewAPIClient())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"count": 1, "next": null, "results": [{"slug": "wish", "name": "Wish"}]}`))
+	}))
+	defer server.Close()
+	file := t.TempDir() + "/spells.json"
+	if err := ioutil.WriteFile(file, []byte(`{"version": 1, "spells": [{"index": "fo`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewSpellFetcher("remote spells", file, server.URL, "open5e", pages, NewEventRegister(AppTest.eventReg.logger, nil, nil))
+	ch := make(chan Spells, 1)
+	f.FetchSpells(context.Background(), ch, false)
+	if spells := <-ch; len(spells) != 1 || spells[0].Index != "wish" {
+		t.Errorf("Expected the refetched spells, but got %v", spells)
+	}
+	if cache, err := loadCache(file); err != nil || len(cache.Spells) != 1 {
+		t.Errorf("Expected the cache to be replaced, got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// CacheFormat is an encoding in which caches can be saved. The checksum of
+// a cache is computed from its spells as they are encoded in the format.
+type CacheFormat struct {
+	// encodes the cache, setting its checksum
+	encode func(cache *CacheEnvelope) ([]byte, error)
+	// decodes a cache and verifies its checksum
+	decode func(data []byte) (*CacheEnvelope, error)
+}
+
+// All formats of caches by their names in the config. Whichever is used,
+// caches in the other formats are still loaded.
+var cacheFormats = map[string]*CacheFormat{
+	// pretty-printed JSON which can be read and edited by hand
+	"json": {
+		encode: func(cache *CacheEnvelope) ([]byte, error) {
+			return encodeJSONCache(cache, true)
+		},
+		decode: decodeJSONCache,
+	},
+	// gzip-compressed JSON, several times smaller
+	"gzip": {
+		encode: func(cache *CacheEnvelope) ([]byte, error) {
+			data, err := encodeJSONCache(cache, false)
+			if err != nil {
+				return nil, err
+			}
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			if _, err := w.Write(data); err != nil {
+				return nil, err
+			}
+			if err := w.Close(); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		},
+		decode: func(data []byte) (*CacheEnvelope, error) {
+			r, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrCorruptCache, err)
+			}
+			data, err = ioutil.ReadAll(r)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrCorruptCache, err)
+			}
+			return decodeJSONCache(data)
+		},
+	},
+	// gob, the fastest to load
+	"gob": {
+		encode: encodeGobCache,
+		decode: decodeGobCache,
+	},
+}
+
+// gob caches start with this so that they can be told apart from the others
+var gobMagic = []byte("litch-gob\n")
+
+// Returns the name of the format of the encoded cache
+func detectCacheFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		return "gzip"
+	case bytes.HasPrefix(data, gobMagic):
+		return "gob"
+	}
+	return "json"
+}
+
+// Encodes the cache as JSON whose checksum is of the compact JSON of the
+// spells
+func encodeJSONCache(cache *CacheEnvelope, indent bool) ([]byte, error) {
+	spells, err := json.Marshal(cache.Spells)
+	if err != nil {
+		return nil, err
+	}
+	cache.Checksum = checksum(spells)
+	if indent {
+		return json.MarshalIndent(cache, "", "    ")
+	}
+	return json.Marshal(cache)
+}
+
+func decodeJSONCache(data []byte) (*CacheEnvelope, error) {
+	var cache CacheEnvelope
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err := json.Unmarshal(data, &cache.Spells)
+		return &cache, err
+	}
+	// the checksum is computed from the spells as they are in the file
+	var raw struct {
+		CacheEnvelope
+		Spells json.RawMessage `json:"spells"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorruptCache, err)
+	}
+	cache = raw.CacheEnvelope
+	if cache.Checksum != "" {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, raw.Spells); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrCorruptCache, err)
+		}
+		if sum := checksum(compact.Bytes()); sum != cache.Checksum {
+			return nil, fmt.Errorf("%w: checksum %s doesn't match %s", ErrCorruptCache, sum, cache.Checksum)
+		}
+	}
+	if err := json.Unmarshal(raw.Spells, &cache.Spells); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorruptCache, err)
+	}
+	return &cache, nil
+}
+
+// gobCache is how caches are saved in gob. Spells are encoded separately so
+// that their checksum can be verified before they are decoded.
+type gobCache struct {
+	Version    int
+	Fetched    time.Time
+	Source     string
+	Validators Validators
+	Checksum   string
+	Spells     []byte
+}
+
+func encodeGobCache(cache *CacheEnvelope) ([]byte, error) {
+	var spells bytes.Buffer
+	if err := gob.NewEncoder(&spells).Encode(cache.Spells); err != nil {
+		return nil, err
+	}
+	cache.Checksum = checksum(spells.Bytes())
+	g := gobCache{cache.Version, cache.Fetched, cache.Source, cache.Validators, cache.Checksum, spells.Bytes()}
+	buf := bytes.NewBuffer(append([]byte(nil), gobMagic...))
+	if err := gob.NewEncoder(buf).Encode(g); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeGobCache(data []byte) (*CacheEnvelope, error) {
+	var g gobCache
+	if err := gob.NewDecoder(bytes.NewReader(data[len(gobMagic):])).Decode(&g); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorruptCache, err)
+	}
+	if sum := checksum(g.Spells); sum != g.Checksum {
+		return nil, fmt.Errorf("%w: checksum %s doesn't match %s", ErrCorruptCache, sum, g.Checksum)
+	}
+	cache := CacheEnvelope{Version: g.Version, Fetched: g.Fetched, Source: g.Source, Validators: g.Validators, Checksum: g.Checksum}
+	if err := gob.NewDecoder(bytes.NewReader(g.Spells)).Decode(&cache.Spells); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorruptCache, err)
+	}
+	return &cache, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheFormats(t *testing.T) {
+	for name, format := range cacheFormats {
+		cache := CacheEnvelope{
+			Version:    CacheSchemaVersion,
+			Fetched:    time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+			Source:     "https://api.open5e.com/spells/",
+			Validators: Validators{ETag: `"v1"`},
+			Spells:     ExampleSpells,
+		}
+		data, err := format.encode(&cache)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if detected := detectCacheFormat(data); detected != name {
+			t.Errorf("%s: detected as %s", name, detected)
+		}
+		output, err := format.decode(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(*output, cache) {
+			t.Errorf("%s: decoded cache differs.\nhave: %#v\nwant: %#v", name, *output, cache)
+		}
+
+		// flip a byte in the middle, where the spells are
+		data[len(data)/2] ^= 0xff
+		if _, err := format.decode(data); !errors.Is(err, ErrCorruptCache) {
+			t.Errorf("%s: expected a damaged cache to be corrupt, but got %v", name, err)
+		}
+	}
+}
+
+func TestCacheMigration(t *testing.T) {
+	var tests = []struct {
+		// config format and the expected format of the cache after loading
+		format string
+		want   string
+	}{
+		{"", "json"},
+		{"json", "json"},
+		{"gzip", "gzip"},
+		{"gob", "gob"},
+	}
+
+	for _, test := range tests {
+		file := t.TempDir() + "/spells.json"
+		// a cache from before the envelope existed
+		legacy, _ := encodeJSONCache(&CacheEnvelope{Spells: ExampleSpells}, true)
+		legacy = legacy[strings.Index(string(legacy), `"spells": `)+len(`"spells": `) : len(legacy)-2]
+		if err := ioutil.WriteFile(file, legacy, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		f := NewSpellFetcher("remote spells", file, "http://localhost/", "open5e", offlinePages, NewEventRegister(AppTest.eventReg.logger, nil, nil))
+		f.cacheFormat = test.format
+		ch := make(chan Spells, 1)
+		f.FetchSpells(context.Background(), ch, false)
+		if spells := <-ch; !reflect.DeepEqual(spells, ExampleSpells) {
+			t.Errorf("%s: unexpected spells: %v", test.format, spells)
+		}
+
+		cache, err := loadCache(file)
+		if err != nil {
+			t.Fatalf("%s: %v", test.format, err)
+		}
+		if cache.format != test.want || cache.Version != CacheSchemaVersion || !reflect.DeepEqual(cache.Spells, ExampleSpells) {
+			t.Errorf("%s: cache was not migrated, got format %s, version %d", test.format, cache.format, cache.Version)
+		}
+	}
+}
+
+// Returns n spells made from the Open5e records in testdata, which have all
+// fields of the API. Words of the texts are shuffled in every copy, so that
+// the copies don't compress better than distinct spells would.
+func benchmarkSpells(b *testing.B, n int) Spells {
+	data, err := ioutil.ReadFile("testdata/open5e_spells.json")
+	if err != nil {
+		b.Fatal(err)
+	}
+	var temp []SpellTemp
+	if err := json.Unmarshal(data, &temp); err != nil {
+		b.Fatal(err)
+	}
+	records := *spellAPIToStandard(&temp)
+
+	rng := rand.New(rand.NewSource(1))
+	shuffle := func(text string) string {
+		words := strings.Fields(text)
+		rng.Shuffle(len(words), func(i, j int) { words[i], words[j] = words[j], words[i] })
+		return strings.Join(words, " ")
+	}
+	spells := make(Spells, n)
+	for i := range spells {
+		s := records[i%len(records)]
+		s.Index = fmt.Sprintf("%s-%d", s.Index, i)
+		s.Name = fmt.Sprintf("%s %d", s.Name, i)
+		s.Desc = shuffle(s.Desc)
+		s.HigherLevel = shuffle(s.HigherLevel)
+		spells[i] = s
+	}
+	sort.Sort(spells)
+	return spells
+}
+
+func BenchmarkLoadCache(b *testing.B) {
+	// about as many spells as Open5e has with all its documents
+	spells := benchmarkSpells(b, 1500)
+	for _, name := range []string{"json", "gzip", "gob"} {
+		b.Run(name, func(b *testing.B) {
+			file := b.TempDir() + "/spells.json"
+			if err := writeCache(file, &CacheEnvelope{Spells: spells}, name); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := loadCache(file); err != nil {
+					b.Fatal(err)
+				}
+			}
+			data, _ := ioutil.ReadFile(file)
+			b.ReportMetric(float64(len(data)), "bytes")
+		})
+	}
+}
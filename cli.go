@@ -0,0 +1,613 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Exit codes of subcommands
+const (
+	exitOK = 0
+	// nothing matched or there is no spell with the index
+	exitNotFound = 1
+	// invalid arguments
+	exitUsage = 2
+	// spells could not be loaded or refetched
+	exitFailed = 3
+)
+
+// Output formats of subcommands
+var outputFormats = []string{"text", "json", "tsv"}
+
+// cliCommand is a subcommand which runs without the terminal UI, per example
+// `litch search fire`
+type cliCommand struct {
+	Name  string
+	Usage string
+	Help  string
+	// args are the arguments after the name of the subcommand
+	run func(c *cli, args []string) int
+}
+
+var cliCommands []cliCommand
+
+// the list refers to the commands, so it is filled in init to avoid an
+// initialization cycle
+func init() {
+	cliCommands = []cliCommand{
+		{"search", "search [flags] <query>", "Print spells matching a query, /text searches descriptions", cliSearch},
+		{"show", "show [flags] <index>", "Print a single spell", cliShow},
+		{"list", "list [flags]", "Print spells matching the given filters", cliList},
+		{"refresh", "refresh [flags]", "Refetch spells of all sources and update caches", cliRefresh},
+		{"export", "export [flags] [query]", "Export spells matching a query, a spellbook or all of them", cliExport},
+		{"help", "help", "Print this help", cliHelp},
+	}
+}
+
+// cli is the state of a running subcommand
+type cli struct {
+	ctx context.Context
+	// files that spells are loaded from
+	env    *Env
+	stdout io.Writer
+	stderr io.Writer
+	// output format, one of outputFormats
+	format  string
+	verbose bool
+}
+
+// Runs the subcommand in args with the files of env and returns the exit
+// code. args don't contain the name of the program.
+func runCLI(ctx context.Context, env *Env, args []string, stdout, stderr io.Writer) int {
+	c := &cli{ctx: ctx, env: env, stdout: stdout, stderr: stderr}
+	name := strings.TrimLeft(args[0], "-")
+	if name == "h" {
+		name = "help"
+	}
+	for _, cmd := range cliCommands {
+		if cmd.Name == name {
+			return cmd.run(c, args[1:])
+		}
+	}
+	c.errorf("unknown command: %s", args[0])
+	c.usage(c.stderr)
+	return exitUsage
+}
+
+// Runs the subcommand given to the program and exits with its exit code.
+// Interrupting the program cancels fetching.
+func runCLIMain(args []string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		cancel()
+	}()
+	code := runCLI(ctx, defaultEnv(), args, os.Stdout, os.Stderr)
+	cancel()
+	os.Exit(code)
+}
+
+func (c *cli) errorf(format string, a ...interface{}) {
+	fmt.Fprintf(c.stderr, "litch: "+format+"\n", a...)
+}
+
+func (c *cli) usage(out io.Writer) {
+	fmt.Fprintln(out, "Usage: litch [command]\n\nWithout a command the terminal UI is started. Commands:")
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, cmd := range cliCommands {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.Usage, cmd.Help)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "\nRun litch <command> -h for flags of a command. Exit codes: %d ok, %d nothing found, %d invalid arguments, %d loading failed.\n",
+		exitOK, exitNotFound, exitUsage, exitFailed)
+}
+
+// Returns a flag set with the flags shared by all commands
+func (c *cli) flags(cmd string) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.StringVar(&c.format, "format", "text", "output format: "+strings.Join(outputFormats, ", "))
+	fs.BoolVar(&c.verbose, "v", false, "log what is happening to stderr")
+	return fs
+}
+
+// Parses flags which can be mixed with positional arguments, per example
+// `search fire --format json`. Returns the positional arguments.
+func (c *cli) parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	// commands without the shared flags have no output format
+	if c.format == "" {
+		return positional, nil
+	}
+	for _, f := range outputFormats {
+		if c.format == f {
+			return positional, nil
+		}
+	}
+	err := fmt.Errorf("unknown format: %s, expected one of %s", c.format, strings.Join(outputFormats, ", "))
+	c.errorf("%s", err)
+	return nil, err
+}
+
+// Returns the exit code for an error returned by parse. Asking for help with
+// -h isn't an error.
+func parseExit(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	return exitUsage
+}
+
+// Loads spells through the same pipeline as the terminal UI. Problems are
+// written to stderr. Returns a non zero exit code if nothing can be used.
+func (c *cli) load(isForce bool) (*spellLoad, int) {
+	var logger *Logger
+	if c.verbose {
+		logger = NewWriterLogger(c.stderr)
+	} else {
+		logger = NewWriterLogger(ioutil.Discard)
+	}
+	defer logger.Close()
+	result, err := loadAllSpells(c.ctx, c.env, isForce, NewEventRegister(logger, nil, nil), nil)
+	if err != nil {
+		c.errorf("fetching of spells was cancelled")
+		return nil, exitFailed
+	}
+	for _, f := range result.fetchers {
+		if f.err != nil {
+			c.errorf("%s", fetchErrStatus(f.name, f.err))
+		}
+	}
+	for _, d := range result.diagnostics {
+		c.errorf("%s", d)
+	}
+	if len(result.spells) == 0 && len(result.failed) > 0 {
+		return nil, exitFailed
+	}
+	return result, exitOK
+}
+
+// Returns the loaded spells from documents that are enabled in the config
+func enabledSpells(result *spellLoad) Spells {
+	spells := Spells{}
+	for _, s := range result.spells {
+		if result.config.DocumentEnabled(s.Document.Slug) {
+			spells = append(spells, s)
+		}
+	}
+	return spells
+}
+
+func cliSearch(c *cli, args []string) int {
+	fs := c.flags("search")
+	sortBy := fs.String("sort", "", "sort by "+strings.Join(sortKeys(), ", ")+" instead of relevance")
+	args, err := c.parse(fs, args)
+	if err != nil {
+		return parseExit(err)
+	}
+	query := strings.TrimSpace(strings.Join(args, " "))
+	if query == "" {
+		c.errorf("missing query")
+		return exitUsage
+	}
+	if _, ok := spellSorters[*sortBy]; !ok && *sortBy != "" {
+		c.errorf("unknown sort key: %s", *sortBy)
+		return exitUsage
+	}
+
+	// the query is checked before loading
+	if _, err := matchSpells(nil, query); err != nil {
+		c.errorf("%s", err)
+		return exitUsage
+	}
+	result, code := c.load(false)
+	if code != exitOK {
+		return code
+	}
+	matched, _ := matchSpells(enabledSpells(result), query)
+	sortSpells(matched, *sortBy)
+	return c.writeSpells(matched)
+}
+
+func cliList(c *cli, args []string) int {
+	fs := c.flags("list")
+	// the filters are turned into a query, so they work like its fields
+	filters := []struct {
+		field string
+		value *string
+	}{
+		{"level", fs.String("level", "", "level like 3 or a range like 1-3")},
+		{"class", fs.String("class", "", "class or subclass")},
+		{"school", fs.String("school", "", "school of magic")},
+		{"list", fs.String("list", "", "spell list, per example wizard")},
+		{"doc", fs.String("doc", "", "source document")},
+		{"ritual", fs.String("ritual", "", "yes or no")},
+		{"conc", fs.String("conc", "", "yes or no")},
+	}
+	sortBy := fs.String("sort", "", "sort by "+strings.Join(sortKeys(), ", "))
+	args, err := c.parse(fs, args)
+	if err != nil {
+		return parseExit(err)
+	}
+	if len(args) > 0 {
+		c.errorf("unexpected argument: %s, use search to search by name", args[0])
+		return exitUsage
+	}
+	if _, ok := spellSorters[*sortBy]; !ok && *sortBy != "" {
+		c.errorf("unknown sort key: %s", *sortBy)
+		return exitUsage
+	}
+	var terms []string
+	for _, f := range filters {
+		if *f.value != "" {
+			terms = append(terms, f.field+":"+strconv.Quote(*f.value))
+		}
+	}
+	q, err := ParseQuery(strings.Join(terms, " "))
+	if err != nil {
+		c.errorf("%s", err)
+		return exitUsage
+	}
+
+	result, code := c.load(false)
+	if code != exitOK {
+		return code
+	}
+	matched := matchQuery(enabledSpells(result), q)
+	sortSpells(matched, *sortBy)
+	return c.writeSpells(matched)
+}
+
+func cliShow(c *cli, args []string) int {
+	args, err := c.parse(c.flags("show"), args)
+	if err != nil {
+		return parseExit(err)
+	}
+	if len(args) != 1 {
+		c.errorf("expected a single spell index")
+		return exitUsage
+	}
+	result, code := c.load(false)
+	if code != exitOK {
+		return code
+	}
+	s := findSpell(result.spells, args[0])
+	if s == nil {
+		c.errorf("no spell %s", args[0])
+		return exitNotFound
+	}
+
+	switch c.format {
+	case "json":
+		return c.writeJSON(s)
+	case "tsv":
+		writeTSV(c.stdout, Spells{*s}, true)
+	default:
+		writeSpellText(c.stdout, s)
+	}
+	return exitOK
+}
+
+func cliRefresh(c *cli, args []string) int {
+	args, err := c.parse(c.flags("refresh"), args)
+	if err != nil {
+		return parseExit(err)
+	}
+	if len(args) > 0 {
+		c.errorf("unexpected argument: %s", args[0])
+		return exitUsage
+	}
+	result, code := c.load(true)
+	if code != exitOK {
+		return code
+	}
+
+	type sourceResult struct {
+		Name   string `json:"name"`
+		Spells int    `json:"spells"`
+		Error  string `json:"error,omitempty"`
+	}
+	var sources []sourceResult
+	for _, f := range result.fetchers {
+		src := sourceResult{Name: f.name, Spells: len(*f.data)}
+		if f.err != nil {
+			src.Error = f.err.Error()
+		}
+		sources = append(sources, src)
+	}
+
+	switch c.format {
+	case "json":
+		c.writeJSON(struct {
+			Spells  int            `json:"spells"`
+			Sources []sourceResult `json:"sources"`
+		}{len(result.spells), sources})
+	case "tsv":
+		fmt.Fprintln(c.stdout, "source\tspells\terror")
+		for _, src := range sources {
+			fmt.Fprintf(c.stdout, "%s\t%d\t%s\n", tsvField(src.Name), src.Spells, tsvField(src.Error))
+		}
+	default:
+		for _, src := range sources {
+			if src.Error == "" {
+				fmt.Fprintf(c.stdout, "%s: %d spells\n", src.Name, src.Spells)
+			} else {
+				fmt.Fprintf(c.stdout, "%s: failed, kept %d spells\n", src.Name, src.Spells)
+			}
+		}
+		fmt.Fprintf(c.stdout, "%d spells in total\n", len(result.spells))
+	}
+	if len(result.failed) > 0 {
+		return exitFailed
+	}
+	return exitOK
+}
+
+func cliExport(c *cli, args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	format := fs.String("format", "markdown", "export format: "+strings.Join(exportFormatNames(), ", "))
+	output := fs.String("o", "", "file to export to instead of stdout")
+	bookName := fs.String("book", "", "export only spells in the spellbook")
+	title := fs.String("title", "", "title of the export, the name of the spellbook or Spells by default")
+	sortBy := fs.String("sort", "", "sort by "+strings.Join(sortKeys(), ", "))
+	fs.BoolVar(&c.verbose, "v", false, "log what is happening to stderr")
+	args, err := c.parse(fs, args)
+	if err != nil {
+		return parseExit(err)
+	}
+	if _, ok := exportFormats[*format]; !ok {
+		c.errorf("unknown export format: %s, expected one of %s", *format, strings.Join(exportFormatNames(), ", "))
+		return exitUsage
+	}
+	if _, ok := spellSorters[*sortBy]; !ok && *sortBy != "" {
+		c.errorf("unknown sort key: %s", *sortBy)
+		return exitUsage
+	}
+	query := strings.TrimSpace(strings.Join(args, " "))
+	if _, err := matchSpells(nil, query); err != nil {
+		c.errorf("%s", err)
+		return exitUsage
+	}
+
+	result, code := c.load(false)
+	if code != exitOK {
+		return code
+	}
+	spells := enabledSpells(result)
+	if *bookName != "" {
+		books, err := LoadSpellbooks(c.env.SpellbookDir)
+		if err != nil {
+			c.errorf("%s", err)
+			return exitFailed
+		}
+		book := books.Get(*bookName)
+		if book == nil {
+			c.errorf("no spellbook named %s", *bookName)
+			return exitNotFound
+		}
+		spells = spellsInBook(spells, book)
+		if *title == "" {
+			*title = book.Name
+		}
+	}
+	if *title == "" {
+		*title = "Spells"
+	}
+	spells, _ = matchSpells(spells, query)
+	sortSpells(spells, *sortBy)
+	if len(spells) == 0 {
+		c.errorf("no spells to export")
+		return exitNotFound
+	}
+
+	if *output == "" {
+		err = exportSpells(c.stdout, *format, *title, spells)
+	} else {
+		err = exportSpellsToFile(*output, *format, *title, spells)
+	}
+	if err != nil {
+		c.errorf("%s", err)
+		return exitFailed
+	}
+	return exitOK
+}
+
+// Returns the spells which are in the book
+func spellsInBook(spells Spells, book *Spellbook) Spells {
+	inBook := Spells{}
+	for _, s := range spells {
+		if book.Entry(s.Index) != nil {
+			inBook = append(inBook, s)
+		}
+	}
+	return inBook
+}
+
+func cliHelp(c *cli, args []string) int {
+	c.usage(c.stdout)
+	return exitOK
+}
+
+// Returns spells matching a query typed like into the input field, the best
+// matches first. Queries starting with / search descriptions.
+func matchSpells(spells Spells, query string) (Spells, error) {
+	if strings.HasPrefix(query, "/") {
+		hits, err := NewSearchIndex(spells).Search(query[1:])
+		if err != nil {
+			return nil, err
+		}
+		matched := Spells{}
+		for _, hit := range hits {
+			matched = append(matched, spells[hit.Doc])
+		}
+		return matched, nil
+	}
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return matchQuery(spells, q), nil
+}
+
+// Returns spells matching the query, the best matches of its free text first
+func matchQuery(spells Spells, q *Query) Spells {
+	matched := Spells{}
+	var scores []int
+	for i := range spells {
+		if q.Match(&spells[i]) {
+			matched = append(matched, spells[i])
+			scores = append(scores, q.Score(&spells[i]))
+		}
+	}
+	if len(q.Terms) > 0 {
+		sort.Stable(byScore{matched, scores})
+	}
+	return matched
+}
+
+// sorts spells by scores, the highest first
+type byScore struct {
+	spells Spells
+	scores []int
+}
+
+func (s byScore) Len() int           { return len(s.spells) }
+func (s byScore) Less(i, j int) bool { return s.scores[i] > s.scores[j] }
+func (s byScore) Swap(i, j int) {
+	s.spells[i], s.spells[j] = s.spells[j], s.spells[i]
+	s.scores[i], s.scores[j] = s.scores[j], s.scores[i]
+}
+
+// Sorts spells by one of the keys in spellSorters, an empty key keeps the order
+func sortSpells(spells Spells, key string) {
+	if less, ok := spellSorters[key]; ok {
+		sort.SliceStable(spells, func(i, j int) bool { return less(spells, i, j) })
+	}
+}
+
+// Returns the spell with the index, or if there is none, the one with the
+// name regardless of capitalisation. Returns nil if neither exists.
+func findSpell(spells Spells, index string) *Spell {
+	for i := range spells {
+		if spells[i].Index == index {
+			return &spells[i]
+		}
+	}
+	for i := range spells {
+		if strings.EqualFold(spells[i].Name, index) {
+			return &spells[i]
+		}
+	}
+	return nil
+}
+
+// Writes spells in the output format. Returns exitNotFound if there are none.
+func (c *cli) writeSpells(spells Spells) int {
+	switch c.format {
+	case "json":
+		if code := c.writeJSON(spells); code != exitOK {
+			return code
+		}
+	case "tsv":
+		writeTSV(c.stdout, spells, false)
+	default:
+		w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+		for i := range spells {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", spells[i].Index, spells[i].Name, levelSchool(&spells[i]), spellFlags(&spells[i]))
+		}
+		w.Flush()
+	}
+	if len(spells) == 0 {
+		return exitNotFound
+	}
+	return exitOK
+}
+
+func (c *cli) writeJSON(v interface{}) int {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		c.errorf("%s", err)
+		return exitFailed
+	}
+	return exitOK
+}
+
+// Writes a detailed description of the spell as plain text
+func writeSpellText(w io.Writer, s *Spell) {
+	fmt.Fprintf(w, "%s\n%s", s.Name, levelSchool(s))
+	if flags := spellFlags(s); flags != "" {
+		fmt.Fprintf(w, " (%s)", flags)
+	}
+	fmt.Fprintln(w)
+	for _, field := range [][2]string{
+		{"Casting Time", s.CastingTime},
+		{"Range", s.Range},
+		{"Components", componentsText(s)},
+		{"Duration", s.Duration},
+		{"Classes", classNames(s)},
+		{"Source", sourceText(s)},
+	} {
+		if field[1] != "" {
+			fmt.Fprintf(w, "%s: %s\n", field[0], field[1])
+		}
+	}
+	fmt.Fprintf(w, "\n%s\n", s.Desc)
+	if s.HigherLevel != "" {
+		fmt.Fprintf(w, "\nAt higher levels: %s\n", s.HigherLevel)
+	}
+}
+
+// Writes spells as tab separated values with a header. Descriptions are
+// included only if withDesc is true.
+func writeTSV(w io.Writer, spells Spells, withDesc bool) {
+	header := []string{"index", "name", "level", "school", "classes", "casting_time", "range", "components", "duration", "ritual", "concentration", "document", "page"}
+	if withDesc {
+		header = append(header, "desc", "higher_level")
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for i := range spells {
+		s := &spells[i]
+		row := []string{s.Index, s.Name, strconv.Itoa(s.Level), s.School.Name, classNames(s), s.CastingTime, s.Range,
+			strings.Join(s.Components, ", "), s.Duration, strconv.FormatBool(s.Ritual), strconv.FormatBool(s.Concentration), s.Document.Slug, s.Page}
+		if withDesc {
+			row = append(row, s.Desc, s.HigherLevel)
+		}
+		for i := range row {
+			row[i] = tsvField(row[i])
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+}
+
+// Escapes characters which would break a TSV row
+var tsvReplacer = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+func tsvField(s string) string {
+	return tsvReplacer.Replace(s)
+}
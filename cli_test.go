@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCLI(t *testing.T) {
+	dir := t.TempDir()
+	env := testEnv(dir, offlinePages)
+
+	// both pages of the API fixture in a single local file
+	var results []json.RawMessage
+	for _, page := range SpellsJSON {
+		var p struct{ Results []json.RawMessage }
+		if err := json.Unmarshal([]byte(page), &p); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, p.Results...)
+	}
+	data, err := json.Marshal(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"/spells.json", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := Config{Sources: []SourceConfig{{Name: "local spells", Local: dir + "/spells.json", Format: "open5e"}}}
+	if err := config.Save(env.ConfigFile); err != nil {
+		t.Fatal(err)
+	}
+	books := &Spellbooks{env.SpellbookDir, map[string]*Spellbook{}}
+	book, err := books.GetOrCreate("Party Wizard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.Add("cone-of-cold")
+	if err := books.Save("Party Wizard"); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		args     string
+		wantCode int
+		// lines the output must contain
+		want []string
+	}{
+		{"search acid", exitOK, []string{"acid-arrow  ", "acid-splash  "}},
+		{"search level:4 --format tsv", exitOK, []string{"index\tname\tlevel", "confusion\tConfusion\t4\t\tBard, Druid, Cleric (Knowledge)\t1 action\t\t\t\tfalse\ttrue\twotc-srd\tphb 224"}},
+		{"search /twists", exitOK, []string{"confusion"}},
+		{"search meteor", exitNotFound, nil},
+		{"search level:", exitUsage, nil},
+		{"search", exitUsage, nil},
+		{"list --level 0-2 --class wizard", exitOK, []string{"acid-arrow   Acid Arrow   Level 2 Evocation", "acid-splash  Acid Splash  Conjuration Cantrip"}},
+		{"list --conc yes --format json", exitOK, []string{`"Index": "confusion"`}},
+		{"list --ritual yes --format json", exitNotFound, []string{"[]"}},
+		{"list fire", exitUsage, nil},
+		{"show cone-of-cold", exitOK, []string{"Cone of Cold", "Level 5 Evocation", "Components: V, S, M (A small crystal or glass cone.)", "Source: wotc-srd", "Blast of air", "At higher levels: 1d8"}},
+		{"show \"acid splash\" --format tsv", exitOK, []string{"acid-splash\tAcid Splash\t0\tConjuration"}},
+		{"show meteor-swarm", exitNotFound, nil},
+		{"show --format xml confusion", exitUsage, nil},
+		{"refresh", exitOK, []string{"local spells: 4 spells", "4 spells in total"}},
+		{"export acid", exitOK, []string{"# Spells", "## Acid Arrow", "## Acid Splash"}},
+		{"export --format html --title Cards level:5", exitOK, []string{"<title>Cards</title>", "<h2>Cone of Cold</h2>"}},
+		{"export --book \"party wizard\"", exitOK, []string{"# Party Wizard", "## Cone of Cold"}},
+		{"export --format pdf", exitUsage, nil},
+		{"export --book meow", exitNotFound, nil},
+		{"export meteor", exitNotFound, nil},
+		{"frobnicate", exitUsage, nil},
+		{"help", exitOK, []string{"Usage: litch"}},
+	}
+
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		code := runCLI(context.Background(), env, splitArgs(test.args), &stdout, &stderr)
+		if code != test.wantCode {
+			t.Errorf("%s: expected exit code %d, but got %d, stderr: %s", test.args, test.wantCode, code, stderr.String())
+		}
+		for _, line := range test.want {
+			if !strings.Contains(stdout.String(), line) {
+				t.Errorf("%s: expected the output to contain %q, but got:\n%s", test.args, line, stdout.String())
+			}
+		}
+	}
+
+	// exports can be written to a file
+	file := dir + "/exports/cards.html"
+	if code := runCLI(context.Background(), env, []string{"export", "-o", file, "--format", "html"}, ioutil.Discard, ioutil.Discard); code != exitOK {
+		t.Errorf("Expected the export to succeed, but got exit code %d", code)
+	}
+	if data, err := ioutil.ReadFile(file); err != nil || strings.Count(string(data), `class="card"`) != 4 {
+		t.Errorf("Expected 4 cards in %s, got %v", file, err)
+	}
+
+	// JSON of a spell is the same as in caches, including unknown fields
+	var stdout bytes.Buffer
+	runCLI(context.Background(), env, []string{"show", "confusion", "--format", "json"}, &stdout, ioutil.Discard)
+	var output Spell
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(output, ExampleSpells[3]) {
+		t.Errorf("Unexpected spell, expected:\n%#v\nbut got:\n%#v", ExampleSpells[3], output)
+	}
+}
+
+// Nothing but the output may be written to stdout, even when the config dir
+// is created on the first run
+func TestCLIFreshConfigDir(t *testing.T) {
+	// the default remote source is served by the API fixture
+	pages := &PageFetcher{
+		Get: func(ctx context.Context, url string, cond Validators) (*apiPage, error) {
+			if url != "2" {
+				url = "1"
+			}
+			return __fetchSpellsTest(ctx, url, cond)
+		},
+		TotalTimeout: time.Minute,
+	}
+	env := testEnv(t.TempDir()+"/litch", pages)
+
+	// stdout of the process is used, so that stray prints end up in it
+	out, err := ioutil.TempFile(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	defer func(f *os.File) { os.Stdout = f }(os.Stdout)
+	os.Stdout = out
+	code := runCLI(context.Background(), env, []string{"list", "--format", "json"}, os.Stdout, ioutil.Discard)
+	if code != exitOK {
+		t.Errorf("Expected exit code %d, but got %d", exitOK, code)
+	}
+
+	data, err := ioutil.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spells Spells
+	if err := json.Unmarshal(data, &spells); err != nil {
+		t.Fatalf("Expected only JSON on stdout, but got %v:\n%s", err, data)
+	}
+	if !reflect.DeepEqual(spells, ExampleSpells) {
+		t.Errorf("Unexpected spells, expected:\n%#v\nbut got:\n%#v", ExampleSpells, spells)
+	}
+}
+
+func TestCLIRefreshFails(t *testing.T) {
+	dir := t.TempDir()
+	env := testEnv(dir, NewPageFetcher(newTestClient()))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	config := Config{Sources: []SourceConfig{{Name: "remote spells", Local: dir + "/cache/spells.json", API: server.URL, Format: "open5e"}}}
+	if err := config.Save(env.ConfigFile); err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr bytes.Buffer
+	if code := runCLI(context.Background(), env, []string{"refresh"}, ioutil.Discard, &stderr); code != exitFailed {
+		t.Errorf("Expected exit code %d, but got %d", exitFailed, code)
+	}
+	if want := "Could not fetch remote spells, the API responded with 503"; !strings.Contains(stderr.String(), want) {
+		t.Errorf("Expected stderr to contain %q, but got %q", want, stderr.String())
+	}
+	if code := runCLI(context.Background(), env, []string{"search", "acid"}, ioutil.Discard, ioutil.Discard); code != exitFailed {
+		t.Errorf("Expected exit code %d without any spells, but got %d", exitFailed, code)
+	}
+}
+
+// Splits args on spaces, except in double quotes
+func splitArgs(args string) []string {
+	var out []string
+	var quoted bool
+	var cur strings.Builder
+	for _, r := range args {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			out = append(out, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	return append(out, cur.String())
+}
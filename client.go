@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatusError is returned when an API responds with an unexpected status
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("GET %s: server responded with %s", e.URL, e.Status)
+}
+
+// ContentTypeError is returned when an API responds with an HTML page, per
+// example with an error page of a proxy or a captive portal
+type ContentTypeError struct {
+	URL         string
+	ContentType string
+}
+
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("GET %s: expected JSON, got %s", e.URL, e.ContentType)
+}
+
+// ErrTimeout is wrapped by errors of requests that took too long
+var ErrTimeout = errors.New("request timed out")
+
+// APIClient fetches pages of APIs. Requests that fail because of the server
+// or the network are retried with an exponential backoff.
+type APIClient struct {
+	client *http.Client
+	// how long a single request can take
+	RequestTimeout time.Duration
+	// how long fetching all pages of a source can take
+	TotalTimeout time.Duration
+	// how many times a failed request is retried
+	MaxRetries int
+	// the delay before the first retry, it doubles with each retry up to
+	// MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// jitter is random so that clients don't retry in lockstep
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewAPIClient returns an APIClient with sensible defaults
+func NewAPIClient() *APIClient {
+	return &APIClient{
+		client:         &http.Client{},
+		RequestTimeout: 20 * time.Second,
+		TotalTimeout:   3 * time.Minute,
+		MaxRetries:     4,
+		BaseDelay:      500 * time.Millisecond,
+		MaxDelay:       15 * time.Second,
+		rand:           rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Get fetches the page at url. If cond isn't empty, the request is
+// conditional and an unchanged page is reported with notModified.
+func (c *APIClient) Get(ctx context.Context, url string, cond Validators) (*apiPage, error) {
+	for attempt := 0; ; attempt++ {
+		page, retryAfter, err := c.get(ctx, url, cond)
+		if err == nil || retryAfter < 0 || attempt >= c.MaxRetries {
+			return page, err
+		}
+		delay := c.backoff(attempt)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctxErr(ctx, url)
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Makes a single request. retryAfter is negative if the request shouldn't be
+// retried, otherwise it is the minimal delay the server asked for.
+func (c *APIClient) get(ctx context.Context, url string, cond Validators) (page *apiPage, retryAfter time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(ctx, c.RequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if cond.ETag != "" {
+		req.Header.Set("If-None-Match", cond.ETag)
+	}
+	if cond.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cond.LastModified)
+	}
+	r, err := c.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctxErr(ctx, url)
+			if errors.Is(err, context.Canceled) {
+				return nil, -1, err
+			}
+		}
+		// network errors and timeouts of a single request are retried
+		return nil, 0, err
+	}
+	defer r.Body.Close()
+
+	page = &apiPage{validators: Validators{r.Header.Get("ETag"), r.Header.Get("Last-Modified")}}
+	switch {
+	case r.StatusCode == http.StatusNotModified:
+		page.notModified = true
+		return page, 0, nil
+	case r.StatusCode == http.StatusTooManyRequests || r.StatusCode >= 500:
+		err := &StatusError{url, r.StatusCode, r.Status}
+		return nil, parseRetryAfter(r.Header.Get("Retry-After"), time.Now()), err
+	case r.StatusCode < 200 || r.StatusCode >= 300:
+		return nil, -1, &StatusError{url, r.StatusCode, r.Status}
+	}
+	// static mirrors can serve JSON as plain text, so only HTML is rejected
+	if ct := r.Header.Get("Content-Type"); strings.Contains(ct, "html") {
+		return nil, -1, &ContentTypeError{url, ct}
+	}
+	page.body, err = io.ReadAll(r.Body)
+	if err != nil && ctx.Err() != nil {
+		return nil, 0, ctxErr(ctx, url)
+	}
+	return page, 0, err
+}
+
+// Returns the delay before the retry after the attempt, with full jitter
+// between a half and the whole of the exponential delay
+func (c *APIClient) backoff(attempt int) time.Duration {
+	delay := c.BaseDelay << uint(attempt)
+	if delay > c.MaxDelay || delay <= 0 {
+		delay = c.MaxDelay
+	}
+	if delay <= 1 {
+		return delay
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return delay/2 + time.Duration(c.rand.Int63n(int64(delay/2)+1))
+}
+
+// Parses the Retry-After header, which is either in seconds or an HTTP date.
+// Returns 0 if it is missing or invalid.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// Returns the error of a done context, timeouts wrap ErrTimeout
+func ctxErr(ctx context.Context, url string) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("GET %s: %w", url, ErrTimeout)
+	}
+	return fmt.Errorf("GET %s: %w", url, ctx.Err())
+}
+
+// errNoSpells is returned when an API responds without any spells. Such
+// responses are never cached.
+var errNoSpells = errors.New("the API returned no spells")
+
+// Returns a status describing why fetching of the named source failed
+func fetchErrStatus(name string, err error) string {
+	var statusErr *StatusError
+	var typeErr *ContentTypeError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return fmt.Sprintf("Fetching %s was cancelled", name)
+	case errors.Is(err, ErrTimeout):
+		return fmt.Sprintf("Could not fetch %s, the API timed out", name)
+	case errors.As(err, &statusErr):
+		return fmt.Sprintf("Could not fetch %s, the API responded with %d", name, statusErr.StatusCode)
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("Could not fetch %s, the API did not respond with JSON", name)
+	case errors.Is(err, errNoSpells):
+		return fmt.Sprintf("Could not fetch %s, the API returned no spells", name)
+	}
+	return fmt.Sprintf("Could not fetch %s from remote API", name)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Returns a client which retries quickly
+func newTestClient() *APIClient {
+	return &APIClient{
+		client:         &http.Client{},
+		RequestTimeout: 200 * time.Millisecond,
+		TotalTimeout:   time.Second,
+		MaxRetries:     2,
+		BaseDelay:      time.Millisecond,
+		MaxDelay:       5 * time.Millisecond,
+		rand:           rand.New(rand.NewSource(1)),
+	}
+}
+
+func TestAPIClientGet(t *testing.T) {
+	var tests = []struct {
+		name string
+		// statuses of responses to consecutive requests, the last one repeats
+		statuses []int
+		header   http.Header
+		wantReqs int32
+		wantErr  error
+		wantCode int
+	}{
+		{"ok", []int{200}, nil, 1, nil, 0},
+		{"server error is retried", []int{503, 502, 200}, nil, 3, nil, 0},
+		{"too many requests is retried", []int{429, 200}, http.Header{"Retry-After": {"0"}}, 2, nil, 0},
+		{"retries run out", []int{500}, nil, 3, nil, 500},
+		{"client error is not retried", []int{404}, nil, 1, nil, 404},
+		{"html page", []int{200}, http.Header{"Content-Type": {"text/html"}}, 1, &ContentTypeError{}, 0},
+		{"slow server times out", []int{-1}, nil, 3, ErrTimeout, 0},
+	}
+
+	for _, test := range tests {
+		var reqs int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := int(atomic.AddInt32(&reqs, 1)) - 1
+			if n >= len(test.statuses) {
+				n = len(test.statuses) - 1
+			}
+			if test.statuses[n] < 0 {
+				time.Sleep(300 * time.Millisecond)
+				return
+			}
+			for key, values := range test.header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(test.statuses[n])
+			w.Write([]byte(`{"results": []}`))
+		}))
+
+		page, err := newTestClient().Get(context.Background(), server.URL, Validators{})
+		server.Close()
+
+		if reqs != test.wantReqs {
+			t.Errorf("%s: expected %d requests, but got %d", test.name, test.wantReqs, reqs)
+		}
+		var statusErr *StatusError
+		var typeErr *ContentTypeError
+		switch {
+		case test.wantCode != 0:
+			if !errors.As(err, &statusErr) || statusErr.StatusCode != test.wantCode {
+				t.Errorf("%s: expected status %d, but got %v", test.name, test.wantCode, err)
+			}
+		case test.wantErr == ErrTimeout:
+			if !errors.Is(err, ErrTimeout) {
+				t.Errorf("%s: expected a timeout, but got %v", test.name, err)
+			}
+		case test.wantErr != nil:
+			if !errors.As(err, &typeErr) {
+				t.Errorf("%s: expected a content type error, but got %v", test.name, err)
+			}
+		case err != nil || string(page.body) != `{"results": []}`:
+			t.Errorf("%s: unexpected result: %v", test.name, err)
+		}
+	}
+}
+
+func TestAPIClientCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := newTestClient()
+	client.MaxRetries = 100
+	client.BaseDelay = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := client.Get(ctx, server.URL, Validators{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancellation, but got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Cancellation took too long: %v", time.Since(start))
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	var tests = []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"5", 5 * time.Second},
+		{"-5", 0},
+		{"Fri, 02 Jan 2026 03:04:15 GMT", 10 * time.Second},
+		{"Fri, 02 Jan 2026 03:04:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, test := range tests {
+		if output := parseRetryAfter(test.header, now); output != test.want {
+			t.Errorf("Unexpected result for %q, expected %v, but got %v", test.header, test.want, output)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	client := newTestClient()
+	client.BaseDelay = 100 * time.Millisecond
+	client.MaxDelay = time.Second
+	for attempt, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		delay := client.backoff(attempt)
+		if delay < max/2 || delay > max {
+			t.Errorf("Delay of attempt %d is %v, expected between %v and %v", attempt, delay, max/2, max)
+		}
+	}
+}
+
+func TestFetchErrStatus(t *testing.T) {
+	var tests = []struct {
+		err  error
+		want string
+	}{
+		{&StatusError{"url", 503, "503 Service Unavailable"}, "Could not fetch spells, the API responded with 503"},
+		{&ContentTypeError{"url", "text/html"}, "Could not fetch spells, the API did not respond with JSON"},
+		{ctxErr(canceledContext(), "url"), "Fetching spells was cancelled"},
+		{errors.New("connection refused"), "Could not fetch spells from remote API"},
+	}
+	for _, test := range tests {
+		if output := fetchErrStatus("spells", test.err); output != test.want {
+			t.Errorf("Unexpected result, expected \"%s\", but got \"%s\"", test.want, output)
+		}
+	}
+}
+
+func canceledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
@@ -0,0 +1,741 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+)
+
+// Command is a named action that can be run from the command mode (Ctrl+N).
+// Commands are typed into the input field and ran on Enter, per example
+// ":level 3" or ":refresh!".
+type Command struct {
+	// name by which the command is invoked
+	Name string
+	// short usage string that is shown by the help command
+	Usage string
+	// one line description of what the command does
+	Desc string
+	// Run executes the command. bang is true if the command name was suffixed
+	// with "!", per example ":refresh!". The returned error will be reported
+	// via EventRegister.
+	Run func(app *App, args []string, bang bool) error
+}
+
+// registry of all available commands, filled in init
+var commands = map[string]*Command{}
+
+// Add a command to the registry. It panics if a command with the same name
+// is already registered since that can only be a programming error
+func registerCommand(c *Command) {
+	if _, ok := commands[c.Name]; ok {
+		panic("command registered twice: " + c.Name)
+	}
+	commands[c.Name] = c
+}
+
+func init() {
+	registerCommand(&Command{
+		Name:  "refresh",
+		Usage: "refresh[!]",
+		Desc:  "reload spells, with ! refetch them from remote APIs",
+		Run:   cmdRefresh,
+	})
+	registerCommand(&Command{
+		Name:  "cancel",
+		Usage: "cancel",
+		Desc:  "cancel loading of spells (also Esc)",
+		Run:   cmdCancel,
+	})
+	registerCommand(&Command{
+		Name:  "level",
+		Usage: "level [0-9]",
+		Desc:  "show only spells of a level, no argument clears the filter",
+		Run:   cmdLevel,
+	})
+	registerCommand(&Command{
+		Name:  "class",
+		Usage: "class [name]",
+		Desc:  "show only spells of a class, no argument clears the filter",
+		Run:   cmdClass,
+	})
+	registerCommand(&Command{
+		Name:  "sort",
+		Usage: "sort [" + strings.Join(sortKeys(), "|") + "]",
+		Desc:  "sort the spell list, no argument sorts by index",
+		Run:   cmdSort,
+	})
+	registerCommand(&Command{
+		Name:  "book",
+		Usage: "book list|show|add|remove|known|prepared|delete [name]",
+		Desc:  "manage spellbooks, add, remove and mark the selected spell in one",
+		Run:   cmdBook,
+	})
+	registerCommand(&Command{
+		Name:  "doc",
+		Usage: "doc list|only|enable|disable|all [slug...]",
+		Desc:  "choose source documents whose spells are shown",
+		Run:   cmdDoc,
+	})
+	registerCommand(&Command{
+		Name:  "char",
+		Usage: "char <name>",
+		Desc:  "select the character whose spell slots are tracked",
+		Run:   cmdChar,
+	})
+	registerCommand(&Command{
+		Name:  "slots",
+		Usage: "slots <level> <count> | slots pact <count> <level>",
+		Desc:  "set the number of spell slots or pact slots of the character",
+		Run:   cmdSlots,
+	})
+	registerCommand(&Command{
+		Name:  "cast",
+		Usage: "cast [slot level]",
+		Desc:  "cast the selected spell spending a slot, optionally upcasting it",
+		Run:   cmdCast,
+	})
+	registerCommand(&Command{
+		Name:  "rest",
+		Usage: "rest short|long",
+		Desc:  "regain pact slots on a short rest or all slots on a long rest",
+		Run:   cmdRest,
+	})
+	registerCommand(&Command{
+		Name:  "activate",
+		Usage: "activate",
+		Desc:  "mark the selected spell as active and count down its duration",
+		Run:   cmdActivate,
+	})
+	registerCommand(&Command{
+		Name:  "end",
+		Usage: "end [number]",
+		Desc:  "end an active spell, no argument ends all of them",
+		Run:   cmdEnd,
+	})
+	registerCommand(&Command{
+		Name:  "round",
+		Usage: "round [count]",
+		Desc:  "advance active spells by a number of combat rounds, 1 by default",
+		Run:   cmdRound,
+	})
+	registerCommand(&Command{
+		Name:  "roll",
+		Usage: "roll [number|dice] [+modifier] | roll history | roll seed <number>",
+		Desc:  "roll dice of the shown spell by their number, or dice like 2d6+3",
+		Run:   cmdRoll,
+	})
+	registerCommand(&Command{
+		Name:  "export",
+		Usage: "export[!] " + strings.Join(exportFormatNames(), "|") + " [file]",
+		Desc:  "export the listed spells, with ! all of them, to a file in the exports directory",
+		Run:   cmdExport,
+	})
+	registerCommand(&Command{
+		Name:  "diag",
+		Usage: "diag",
+		Desc:  "show problems with custom spells that were skipped while loading",
+		Run:   cmdDiag,
+	})
+	registerCommand(&Command{
+		Name:  "quit",
+		Usage: "quit",
+		Desc:  "quit the app",
+		Run:   cmdQuit,
+	})
+	registerCommand(&Command{
+		Name:  "help",
+		Usage: "help [command]",
+		Desc:  "show all commands or usage of a single one",
+		Run:   cmdHelp,
+	})
+}
+
+// Split a command line into the command name, its arguments and whether the
+// command was banged. The leading ":" is optional.
+func parseCommand(line string) (name string, args []string, bang bool) {
+	line = strings.TrimPrefix(strings.TrimSpace(line), ":")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", nil, false
+	}
+	name = fields[0]
+	if strings.HasSuffix(name, "!") {
+		name = strings.TrimSuffix(name, "!")
+		bang = true
+	}
+	return strings.ToLower(name), fields[1:], bang
+}
+
+// Run a command line. Errors are reported via EventRegister and returned for
+// testing purposes.
+func (app *App) execCommand(line string) error {
+	name, args, bang := parseCommand(line)
+	if name == "" {
+		return nil
+	}
+	var err error
+	if cmd, ok := commands[name]; ok {
+		err = cmd.Run(app, args, bang)
+	} else {
+		err = fmt.Errorf("Unknown command: %s", name)
+	}
+	if err != nil {
+		app.eventReg.Register(EventErr, fmt.Sprintf("command \"%s\" failed: %s", line, err), err.Error())
+	}
+	return err
+}
+
+func cmdRefresh(app *App, args []string, bang bool) error {
+	go app.FetchData(bang)
+	return nil
+}
+
+func cmdCancel(app *App, args []string, bang bool) error {
+	if !app.CancelFetch() {
+		return fmt.Errorf("No spells are being loaded")
+	}
+	return nil
+}
+
+func cmdLevel(app *App, args []string, bang bool) error {
+	if len(args) == 0 {
+		app.levelFilter = -1
+		app.updateSpellList()
+		return nil
+	}
+	lvl, err := strconv.Atoi(args[0])
+	if err != nil || lvl < 0 || lvl > 9 {
+		return fmt.Errorf("Invalid level: %s", args[0])
+	}
+	app.levelFilter = lvl
+	app.updateSpellList()
+	return nil
+}
+
+func cmdClass(app *App, args []string, bang bool) error {
+	// class names can have spaces in them, per example "Cleric (Knowledge)"
+	app.classFilter = strings.ToLower(strings.Join(args, " "))
+	app.updateSpellList()
+	return nil
+}
+
+func cmdSort(app *App, args []string, bang bool) error {
+	if len(args) == 0 {
+		app.sortBy = ""
+		app.updateSpellList()
+		return nil
+	}
+	key := strings.ToLower(args[0])
+	if _, ok := spellSorters[key]; !ok {
+		return fmt.Errorf("Cannot sort by %s", args[0])
+	}
+	app.sortBy = key
+	app.updateSpellList()
+	return nil
+}
+
+func cmdBook(app *App, args []string, bang bool) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Usage: :%s", commands["book"].Usage)
+	}
+	sub, name := args[0], strings.Join(args[1:], " ")
+	switch sub {
+	case "list":
+		var text string
+		for _, n := range app.books.Names() {
+			book := app.books.Get(n)
+			text += fmt.Sprintf("[orange]%s[white]\n    %d spells\n", book.Name, len(book.Spells))
+		}
+		app.showInfo("Spellbooks", text)
+		return nil
+	case "show":
+		if name != "" && app.books.Get(name) == nil {
+			return fmt.Errorf("No spellbook named %s", name)
+		}
+		// no name shows all spells again
+		app.bookFilter = name
+		app.updateSpellList()
+		return nil
+	case "delete":
+		if err := app.books.Delete(name); err != nil {
+			return err
+		}
+		if strings.EqualFold(app.bookFilter, name) {
+			app.bookFilter = ""
+		}
+		app.updateSpellList()
+		app.eventReg.Register(EventInfo, "Deleted spellbook "+name, "Deleted spellbook "+name)
+		return nil
+	}
+
+	// the rest of the subcommands work with the selected spell
+	if name == "" {
+		// default to the book that is shown atm
+		name = app.bookFilter
+	}
+	if name == "" {
+		return fmt.Errorf("Missing spellbook name")
+	}
+	spell := app.currentSelectedSpell()
+	if spell == nil {
+		return fmt.Errorf("No spell selected")
+	}
+	var book *Spellbook
+	switch sub {
+	case "add":
+		var err error
+		if book, err = app.books.GetOrCreate(name); err != nil {
+			return err
+		}
+		if !book.Add(spell.Index) {
+			return fmt.Errorf("%s is already in %s", spell.Name, book.Name)
+		}
+	case "remove", "known", "prepared":
+		if book = app.books.Get(name); book == nil {
+			return fmt.Errorf("No spellbook named %s", name)
+		}
+		entry := book.Entry(spell.Index)
+		if entry == nil {
+			return fmt.Errorf("%s is not in %s", spell.Name, book.Name)
+		}
+		switch sub {
+		case "remove":
+			book.Remove(spell.Index)
+		case "known":
+			entry.Known = !entry.Known
+		case "prepared":
+			entry.Prepared = !entry.Prepared
+		}
+	default:
+		return fmt.Errorf("Unknown subcommand: %s", sub)
+	}
+	if err := app.books.Save(book.Name); err != nil {
+		return fmt.Errorf("Could not save spellbook %s: %s", book.Name, err)
+	}
+	app.updateSpellList()
+	return nil
+}
+
+func cmdDoc(app *App, args []string, bang bool) error {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	sub, slugs := args[0], args[1:]
+	docs := app.documents()
+	// the choice is made in a copy, which replaces the config once it is
+	// saved, so that the app never uses a choice that wasn't saved
+	config := *app.config
+	// the default config is used if the config file couldn't be loaded, and
+	// saving it would throw away everything else that is in the file
+	if sub != "list" && app.configErr != nil {
+		return fmt.Errorf("Config could not be loaded, so the choice can't be saved: %s", app.configErr)
+	}
+	switch sub {
+	case "list":
+		var text string
+		for _, d := range docs {
+			mark := "[red]disabled"
+			if config.DocumentEnabled(d.Slug) {
+				mark = "[green]enabled"
+			}
+			text += fmt.Sprintf("[orange]%s[white] %s[white]\n    %s, %d spells\n", d.Slug, mark, tview.Escape(d.Title), d.Count)
+		}
+		app.showInfo("Documents", text)
+		return nil
+	case "all":
+		config.Documents = nil
+	case "only", "enable", "disable":
+		if len(slugs) == 0 {
+			return fmt.Errorf("Missing document slug")
+		}
+		for _, slug := range slugs {
+			if !hasDocument(docs, slug) {
+				return fmt.Errorf("No document with slug %s, see :doc list", slug)
+			}
+		}
+		enabled := map[string]bool{}
+		for _, d := range docs {
+			enabled[d.Slug] = sub != "only" && config.DocumentEnabled(d.Slug)
+		}
+		for _, slug := range slugs {
+			enabled[slug] = sub != "disable"
+		}
+		var documents []string
+		for _, d := range docs {
+			if enabled[d.Slug] {
+				documents = append(documents, d.Slug)
+			}
+		}
+		if len(documents) == 0 {
+			return fmt.Errorf("At least one document must be enabled")
+		}
+		// all documents enabled is the same as no filter, and it also
+		// shows documents that are added later
+		if len(documents) == len(docs) {
+			documents = nil
+		}
+		config.Documents = documents
+	default:
+		return fmt.Errorf("Unknown subcommand: %s", sub)
+	}
+	if err := config.Save(app.env.ConfigFile); err != nil {
+		return fmt.Errorf("Could not save config: %s", err)
+	}
+	app.config = &config
+	app.configChanges++
+	app.updateSpellList()
+	return nil
+}
+
+func hasDocument(docs []documentInfo, slug string) bool {
+	for _, d := range docs {
+		if d.Slug == slug {
+			return true
+		}
+	}
+	return false
+}
+
+func cmdChar(app *App, args []string, bang bool) error {
+	name := strings.Join(args, " ")
+	if name == "" {
+		return fmt.Errorf("Missing character name")
+	}
+	slots, err := LoadSlotTracker(app.env.SlotDir, name)
+	if err != nil {
+		return fmt.Errorf("Could not load slots of %s: %s", name, err)
+	}
+	app.slots = slots
+	app.updateSlots()
+	return nil
+}
+
+func cmdSlots(app *App, args []string, bang bool) error {
+	if app.slots == nil {
+		return fmt.Errorf("No character selected")
+	}
+	if len(args) != 2 && !(len(args) == 3 && args[0] == "pact") {
+		return fmt.Errorf("Usage: :%s", commands["slots"].Usage)
+	}
+	var nums []int
+	for _, arg := range args[len(args)-2:] {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("Not a number: %s", arg)
+		}
+		nums = append(nums, n)
+	}
+	var err error
+	if len(args) == 3 {
+		err = app.slots.SetPact(nums[0], nums[1])
+	} else {
+		err = app.slots.SetMax(nums[0], nums[1])
+	}
+	if err != nil {
+		return err
+	}
+	return app.saveSlots()
+}
+
+func cmdCast(app *App, args []string, bang bool) error {
+	if app.slots == nil {
+		return fmt.Errorf("No character selected")
+	}
+	spell := app.currentSelectedSpell()
+	if spell == nil {
+		return fmt.Errorf("No spell selected")
+	}
+	var atLevel int
+	if len(args) > 0 {
+		var err error
+		if atLevel, err = strconv.Atoi(args[0]); err != nil {
+			return fmt.Errorf("Not a number: %s", args[0])
+		}
+	}
+	slot, pact, err := app.slots.Cast(spell.Level, atLevel)
+	if err != nil {
+		return err
+	}
+	if err := app.saveSlots(); err != nil {
+		return err
+	}
+	info := fmt.Sprintf("%s cast %s", app.slots.Character, spell.Name)
+	switch {
+	case pact:
+		info += fmt.Sprintf(" using a level %d pact slot", slot)
+	case slot > 0:
+		info += fmt.Sprintf(" using a level %d slot", slot)
+	}
+	app.eventReg.Register(EventInfo, info, info)
+	return nil
+}
+
+func cmdRest(app *App, args []string, bang bool) error {
+	if app.slots == nil {
+		return fmt.Errorf("No character selected")
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("Usage: :%s", commands["rest"].Usage)
+	}
+	switch args[0] {
+	case "short":
+		app.slots.ShortRest()
+	case "long":
+		app.slots.LongRest()
+	default:
+		return fmt.Errorf("Unknown rest: %s", args[0])
+	}
+	if err := app.saveSlots(); err != nil {
+		return err
+	}
+	info := fmt.Sprintf("%s took a %s rest", app.slots.Character, args[0])
+	app.eventReg.Register(EventInfo, info, info)
+	return nil
+}
+
+// Saves slots of the selected character and updates them on the screen
+func (app *App) saveSlots() error {
+	app.updateSlots()
+	if err := app.slots.Save(app.env.SlotDir); err != nil {
+		return fmt.Errorf("Could not save slots of %s: %s", app.slots.Character, err)
+	}
+	return nil
+}
+
+func cmdActivate(app *App, args []string, bang bool) error {
+	spell := app.currentSelectedSpell()
+	if spell == nil {
+		return fmt.Errorf("No spell selected")
+	}
+	replaced, err := app.effects.Activate(spell, time.Now())
+	if err != nil {
+		return err
+	}
+	app.updateEffects()
+	app.wakeEffects()
+	if replaced != nil {
+		warn := fmt.Sprintf("Concentration on %s ended, now concentrating on %s", replaced.Name, spell.Name)
+		app.eventReg.Register(EventWarn, warn, warn)
+		return nil
+	}
+	info := fmt.Sprintf("%s is active", spell.Name)
+	app.eventReg.Register(EventInfo, info, info)
+	return nil
+}
+
+func cmdEnd(app *App, args []string, bang bool) error {
+	if len(args) == 0 {
+		app.effects.Effects = nil
+		app.updateEffects()
+		return nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("Not a number: %s", args[0])
+	}
+	e, err := app.effects.End(n - 1)
+	if err != nil {
+		return err
+	}
+	app.updateEffects()
+	info := fmt.Sprintf("%s has ended", e.Name)
+	app.eventReg.Register(EventInfo, info, info)
+	return nil
+}
+
+func cmdRound(app *App, args []string, bang bool) error {
+	rounds := 1
+	if len(args) > 0 {
+		var err error
+		if rounds, err = strconv.Atoi(args[0]); err != nil || rounds < 1 {
+			return fmt.Errorf("Invalid number of rounds: %s", args[0])
+		}
+	}
+	app.effects.Advance(rounds)
+	// effects that ran out are reported on the next tick
+	app.updateEffects()
+	return nil
+}
+
+func cmdRoll(app *App, args []string, bang bool) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "history":
+			if len(app.rolls.Rolls) == 0 {
+				app.showInfo("Rolls", "No dice rolled yet")
+				return nil
+			}
+			app.showInfo("Rolls", app.rolls.Render())
+			return nil
+		case "seed":
+			if len(args) < 2 {
+				return fmt.Errorf("Usage: :%s", commands["roll"].Usage)
+			}
+			seed, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("Not a number: %s", args[1])
+			}
+			app.roller.Seed(seed)
+			return nil
+		}
+	}
+
+	var label string
+	var dice Dice
+	n := 1
+	var err error
+	if len(args) > 0 {
+		n, err = strconv.Atoi(args[0])
+	}
+	if len(args) == 0 || err == nil && (len(args) == 1 || len(args) == 2 && isModifier(args[1])) {
+		// dice of the shown spell, the first ones by default
+		spell := app.shownSpell
+		if spell == nil {
+			spell = app.currentSelectedSpell()
+		}
+		if spell == nil {
+			return fmt.Errorf("No spell selected")
+		}
+		all := spellDice(spell)
+		if len(all) == 0 {
+			return fmt.Errorf("No dice in %s", spell.Name)
+		}
+		if n < 1 || n > len(all) {
+			return fmt.Errorf("%s has dice numbered 1 to %d", spell.Name, len(all))
+		}
+		label, dice = spell.Name, all[n-1]
+		if len(args) == 2 {
+			mod, _ := strconv.Atoi(args[1])
+			dice.Modifier += mod
+		}
+	} else if dice, err = parseDice(strings.Join(args, "")); err != nil {
+		return err
+	}
+
+	roll := app.roller.Roll(label, dice)
+	app.rolls.Add(roll)
+	app.eventReg.Register(EventInfo, roll.String(), roll.String())
+	return nil
+}
+
+// Returns whether the argument is a modifier like +4 or -1
+func isModifier(arg string) bool {
+	if !strings.HasPrefix(arg, "+") && !strings.HasPrefix(arg, "-") {
+		return false
+	}
+	_, err := strconv.Atoi(arg[1:])
+	return err == nil
+}
+
+func cmdExport(app *App, args []string, bang bool) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Usage: :%s", commands["export"].Usage)
+	}
+	format, ok := exportFormats[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown export format: %s, expected one of %s", args[0], strings.Join(exportFormatNames(), ", "))
+	}
+	title := "Spells"
+	if book := app.books.Get(app.bookFilter); book != nil {
+		title = book.Name
+	}
+	spells := *app.spells
+	if !bang {
+		// the spells as they are listed, filtered and sorted
+		spells = nil
+		for _, i := range app.listed {
+			spells = append(spells, (*app.spells)[i])
+		}
+	}
+	if len(spells) == 0 {
+		return fmt.Errorf("No spells to export")
+	}
+
+	file := strings.Join(args[1:], " ")
+	if file == "" {
+		file = safeFileName(title) + format.Ext
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(app.env.ExportDir, file)
+	}
+	if err := exportSpellsToFile(file, args[0], title, spells); err != nil {
+		return err
+	}
+	info := fmt.Sprintf("Exported %d spells to %s", len(spells), file)
+	app.eventReg.Register(EventInfo, info, info)
+	return nil
+}
+
+func cmdDiag(app *App, args []string, bang bool) error {
+	if len(app.diagnostics) == 0 {
+		app.showInfo("Diagnostics", "No problems found")
+		return nil
+	}
+	var text string
+	for _, d := range app.diagnostics {
+		text += fmt.Sprintf("[orange]%s:%d:%d[white]\n    %s\n", d.File, d.Line, d.Column, tview.Escape(d.Message))
+	}
+	app.showInfo("Diagnostics", text)
+	return nil
+}
+
+func cmdQuit(app *App, args []string, bang bool) error {
+	app.Quit()
+	app.app.Stop()
+	return nil
+}
+
+func cmdHelp(app *App, args []string, bang bool) error {
+	if len(args) > 0 {
+		// the name is looked up the way it would be run, so ":help refresh!"
+		// works too
+		name, _, _ := parseCommand(args[0])
+		cmd, ok := commands[name]
+		if !ok {
+			return fmt.Errorf("Unknown command: %s", args[0])
+		}
+		app.setStatus(":" + cmd.Usage + " - " + cmd.Desc)
+		return nil
+	}
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var text string
+	for _, name := range names {
+		cmd := commands[name]
+		text += fmt.Sprintf("[orange]:%s[white]\n    %s\n", cmd.Usage, cmd.Desc)
+	}
+	app.showInfo("Commands", text)
+	return nil
+}
+
+// Functions that compare spells i and j for each key the spell list can be
+// sorted by
+var spellSorters = map[string]func(s Spells, i, j int) bool{
+	"index": func(s Spells, i, j int) bool { return s[i].Index < s[j].Index },
+	"name": func(s Spells, i, j int) bool {
+		return strings.ToLower(s[i].Name) < strings.ToLower(s[j].Name)
+	},
+	"level":  func(s Spells, i, j int) bool { return s[i].Level < s[j].Level },
+	"school": func(s Spells, i, j int) bool { return s[i].School.Name < s[j].School.Name },
+	"range":  func(s Spells, i, j int) bool { return s[i].RangeSort < s[j].RangeSort },
+}
+
+// Returns all the keys the spell list can be sorted by, sorted
+func sortKeys() []string {
+	var keys []string
+	for k := range spellSorters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCommand(t *testing.T) {
+	var tests = []struct {
+		line string
+		name string
+		args []string
+		bang bool
+	}{
+		{":refresh", "refresh", []string{}, false},
+		{"refresh!", "refresh", []string{}, true},
+		{":level  3 ", "level", []string{"3"}, false},
+		{":Class Cleric (Knowledge)", "class", []string{"Cleric", "(Knowledge)"}, false},
+		{"  ", "", nil, false},
+	}
+
+	for _, test := range tests {
+		name, args, bang := parseCommand(test.line)
+		if name != test.name || bang != test.bang || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("Unexpected result for \"%s\", expected: %q %q %v, but got %q %q %v", test.line, test.name, test.args, test.bang, name, args, bang)
+		}
+	}
+}
+
+func TestExecCommand(t *testing.T) {
+	var tests = []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		// 2 Acid Arrow, 0 Acid Splash, 5 Cone of Cold, 4 Confusion
+		{":level 5", []string{"5 Cone of Cold"}, false},
+		{":level", []string{"2 Acid Arrow", "0 Acid Splash", "5 Cone of Cold", "4 Confusion"}, false},
+		{":level 10", []string{"2 Acid Arrow", "0 Acid Splash", "5 Cone of Cold", "4 Confusion"}, true},
+		{":class sorcerer", []string{"0 Acid Splash", "5 Cone of Cold"}, false},
+		{":class knowledge", []string{"4 Confusion"}, false},
+		{":class", []string{"2 Acid Arrow", "0 Acid Splash", "5 Cone of Cold", "4 Confusion"}, false},
+		{":sort level", []string{"0 Acid Splash", "2 Acid Arrow", "4 Confusion", "5 Cone of Cold"}, false},
+		{":sort school", []string{"4 Confusion", "0 Acid Splash", "2 Acid Arrow", "5 Cone of Cold"}, false},
+		{":sort meow", []string{"4 Confusion", "0 Acid Splash", "2 Acid Arrow", "5 Cone of Cold"}, true},
+		{":sort", []string{"2 Acid Arrow", "0 Acid Splash", "5 Cone of Cold", "4 Confusion"}, false},
+		{":meow", []string{"2 Acid Arrow", "0 Acid Splash", "5 Cone of Cold", "4 Confusion"}, true},
+	}
+
+	AppTest.spells = &ExampleSpells
+	AppTest.setInputText("")
+	for _, test := range tests {
+		err := AppTest.execCommand(test.line)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Unexpected error for \"%s\": %v", test.line, err)
+		}
+		output := AppTest.updateSpellList()
+		if !reflect.DeepEqual(*output, test.want) {
+			t.Errorf("Unexpected result for \"%s\".\nhave: \"%#v\"\nwant: \"%#v\"", test.line, *output, test.want)
+		}
+	}
+}
+
+func TestHelpCommand(t *testing.T) {
+	var tests = []struct {
+		line    string
+		want    string
+		wantErr bool
+	}{
+		{":help roll", ":roll ", false},
+		{":help :roll", ":roll ", false},
+		{":help refresh!", ":refresh[!] ", false},
+		{":help :Refresh!", ":refresh[!] ", false},
+		{":help meow", "", true},
+		{":help !", "", true},
+	}
+	// the status box of AppTest is written by its status goroutine
+	app := &App{statusBox: getStatusBox(), eventReg: NewEventRegister(AppTest.eventReg.logger, nil, nil)}
+	for _, test := range tests {
+		app.setStatus("")
+		err := app.execCommand(test.line)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Unexpected error for \"%s\": %v", test.line, err)
+		}
+		if status := app.statusBox.GetText(true); !strings.HasPrefix(status, test.want) {
+			t.Errorf("Unexpected usage for \"%s\", expected \"%s...\", but got \"%s\"", test.line, test.want, status)
+		}
+	}
+}
+
+func TestDocCommand(t *testing.T) {
+	// the spells and the config are replaced once the initial fetch ends
+	for start := time.Now(); AppTest.fetching(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("The initial fetch did not end")
+		}
+	}
+	defer func(file string) { AppTest.env.ConfigFile = file }(AppTest.env.ConfigFile)
+	AppTest.env.ConfigFile = t.TempDir() + "/config.json"
+	defer func(c *Config, err error) { AppTest.config, AppTest.configErr = c, err }(AppTest.config, AppTest.configErr)
+	AppTest.config, AppTest.configErr = defaultConfig(), nil
+
+	spells := append(Spells(nil), ExampleSpells...)
+	spells[2].Document.Slug = "dmag"
+	var tests = []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		// Acid Splash has no document, so it is always shown
+		{":doc only wotc-srd", []string{"2 Acid Arrow", "0 Acid Splash", "4 Confusion"}, false},
+		{":doc enable dmag", []string{"2 Acid Arrow", "0 Acid Splash", "5 Cone of Cold", "4 Confusion"}, false},
+		{":doc disable wotc-srd", []string{"0 Acid Splash", "5 Cone of Cold"}, false},
+		{":doc disable dmag", []string{"0 Acid Splash", "5 Cone of Cold"}, true},
+		{":doc only meow", []string{"0 Acid Splash", "5 Cone of Cold"}, true},
+		{":doc all", []string{"2 Acid Arrow", "0 Acid Splash", "5 Cone of Cold", "4 Confusion"}, false},
+		{":doc list", []string{"2 Acid Arrow", "0 Acid Splash", "5 Cone of Cold", "4 Confusion"}, false},
+	}
+
+	AppTest.spells = &spells
+	AppTest.setInputText("")
+	for _, test := range tests {
+		err := AppTest.execCommand(test.line)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Unexpected error for \"%s\": %v", test.line, err)
+		}
+		output := AppTest.updateSpellList()
+		if !reflect.DeepEqual(*output, test.want) {
+			t.Errorf("Unexpected result for \"%s\".\nhave: \"%#v\"\nwant: \"%#v\"", test.line, *output, test.want)
+		}
+	}
+
+	// the choice is saved in the config
+	AppTest.execCommand(":doc only dmag")
+	config, err := LoadConfig(AppTest.env.ConfigFile)
+	if err != nil || !reflect.DeepEqual(config.Documents, []string{"dmag"}) {
+		t.Errorf("Expected the documents to be saved, got %v, %v", config.Documents, err)
+	}
+
+	// a choice that can't be saved isn't used
+	saved := AppTest.env.ConfigFile
+	AppTest.env.ConfigFile = saved + "/config.json"
+	if err := AppTest.execCommand(":doc all"); err == nil {
+		t.Errorf("Expected an error when the config can't be saved")
+	}
+	AppTest.env.ConfigFile = saved
+	if !reflect.DeepEqual(AppTest.config.Documents, []string{"dmag"}) {
+		t.Errorf("Expected the documents to stay as they were, but got %v", AppTest.config.Documents)
+	}
+
+	// a fetch that started before the choice doesn't revert it
+	AppTest.setLoad(&spellLoad{spells: spells, config: defaultConfig(), configChanges: AppTest.configChanges - 1})
+	if !reflect.DeepEqual(AppTest.config.Documents, []string{"dmag"}) {
+		t.Errorf("Expected the choice to be kept after a fetch, but got %v", AppTest.config.Documents)
+	}
+
+	// the default config that is used instead of a broken one isn't saved
+	// over it
+	ioutil.WriteFile(AppTest.env.ConfigFile, []byte("{broken"), 0644)
+	AppTest.configErr = errors.New("invalid config")
+	if err := AppTest.execCommand(":doc all"); err == nil {
+		t.Errorf("Expected an error when the config wasn't loaded")
+	}
+	if data, _ := ioutil.ReadFile(AppTest.env.ConfigFile); string(data) != "{broken" {
+		t.Errorf("Expected the config file to stay untouched, but got %q", data)
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	defer func(dir string) { AppTest.env.ExportDir = dir }(AppTest.env.ExportDir)
+	AppTest.env.ExportDir = t.TempDir()
+
+	var tests = []struct {
+		line string
+		// file the spells are exported to, relative to the export dir
+		file    string
+		want    []string
+		wantErr bool
+	}{
+		// only the listed spells are exported, "acid" is typed in
+		{":export markdown", "spells.md", []string{"## Acid Arrow", "## Acid Splash"}, false},
+		{":export! html all.html", "all.html", []string{"<h2>Acid Arrow</h2>", "<h2>Cone of Cold</h2>", "<h2>Confusion</h2>"}, false},
+		{":export pdf", "", nil, true},
+		{":export", "", nil, true},
+	}
+
+	AppTest.spells = &ExampleSpells
+	AppTest.setInputText("acid")
+	defer AppTest.setInputText("")
+	for _, test := range tests {
+		err := AppTest.execCommand(test.line)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Unexpected error for \"%s\": %v", test.line, err)
+		}
+		if test.file == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(AppTest.env.ExportDir + "/" + test.file)
+		if err != nil {
+			t.Errorf("%s: %v", test.line, err)
+			continue
+		}
+		for _, want := range test.want {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("%s: expected the export to contain %q", test.line, want)
+			}
+		}
+		if test.line == ":export markdown" && strings.Contains(string(data), "Cone of Cold") {
+			t.Errorf("%s: expected only the listed spells to be exported", test.line)
+		}
+	}
+}
+
+func TestRollCommand(t *testing.T) {
+	fireball := Spell{Name: "Fireball", Desc: "Each creature takes 8d6 fire damage.", HigherLevel: "The damage increases by 1d6 for each slot level above 3rd."}
+	AppTest.showSpell(&fireball)
+	defer AppTest.showSpell(nil)
+	AppTest.rolls = RollHistory{}
+
+	var tests = []struct {
+		line    string
+		want    Dice
+		wantErr bool
+	}{
+		{":roll", Dice{Count: 8, Sides: 6}, false},
+		{":roll 2", Dice{Count: 1, Sides: 6}, false},
+		{":roll 1 +3", Dice{Count: 8, Sides: 6, Modifier: 3}, false},
+		{":roll 2d4 + 1", Dice{Count: 2, Sides: 4, Modifier: 1}, false},
+		{":roll 3", Dice{}, true},
+		{":roll fireball", Dice{}, true},
+		{":roll seed", Dice{}, true},
+	}
+	for _, test := range tests {
+		before := len(AppTest.rolls.Rolls)
+		err := AppTest.execCommand(test.line)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Unexpected error for \"%s\": %v", test.line, err)
+		}
+		if test.wantErr {
+			if len(AppTest.rolls.Rolls) != before {
+				t.Errorf("%s: expected nothing to be rolled", test.line)
+			}
+			continue
+		}
+		if len(AppTest.rolls.Rolls) != before+1 {
+			t.Fatalf("%s: expected the roll to be added to the history", test.line)
+		}
+		got := AppTest.rolls.Rolls[before].Dice
+		if got.Count != test.want.Count || got.Sides != test.want.Sides || got.Modifier != test.want.Modifier {
+			t.Errorf("%s: expected %s to be rolled, but got %s", test.line, test.want, got)
+		}
+	}
+
+	// seeding makes the rolls repeat
+	AppTest.execCommand(":roll seed 7")
+	AppTest.execCommand(":roll")
+	AppTest.execCommand(":roll seed 7")
+	AppTest.execCommand(":roll")
+	rolls := AppTest.rolls.Rolls
+	if !reflect.DeepEqual(rolls[len(rolls)-1], rolls[len(rolls)-2]) {
+		t.Errorf("Expected the same rolls after seeding, but got %v and %v", rolls[len(rolls)-2], rolls[len(rolls)-1])
+	}
+
+	// once the spell is replaced by other info, the selected spell is rolled
+	spells := Spells{{Index: "cure-wounds", Name: "Cure Wounds", Desc: "A creature regains 1d8 hit points."}}
+	AppTest.spells = &spells
+	AppTest.setInputText("")
+	AppTest.execCommand(":help")
+	if err := AppTest.execCommand(":roll"); err != nil {
+		t.Fatalf("Unexpected error for \":roll\" after \":help\": %v", err)
+	}
+	if last := AppTest.rolls.Rolls[len(AppTest.rolls.Rolls)-1]; last.Label != "Cure Wounds" || last.Dice.Sides != 8 {
+		t.Errorf("Expected the selected spell to be rolled after :help, but got %s", last)
+	}
+}
+
+func TestActivateCommand(t *testing.T) {
+	spells := Spells{
+		{Index: "fireball", Name: "Fireball", Duration: "Instantaneous"},
+		{Index: "mage-armor", Name: "Mage Armor", Duration: "8 hours"},
+	}
+	AppTest.spells = &spells
+	AppTest.setInputText("")
+	// the countdown doesn't run in tests, so nothing takes the wake up
+	defer func() {
+		AppTest.execCommand(":end")
+		select {
+		case <-AppTest.effectsChan:
+		default:
+		}
+	}()
+
+	// the countdown sleeps until an effect is activated
+	if err := AppTest.execCommand(":activate"); err == nil {
+		t.Errorf("Expected an error for an instantaneous spell")
+	}
+	if len(AppTest.effectsChan) != 0 {
+		t.Errorf("Expected the countdown to stay asleep")
+	}
+	AppTest.list.SetCurrentItem(1)
+	if err := AppTest.execCommand(":activate"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(AppTest.effectsChan) != 1 {
+		t.Errorf("Expected the countdown to be woken up")
+	}
+}
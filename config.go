@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SourceConfig describes a single source of spells in the config file
+type SourceConfig struct {
+	// identifier of the source, shown in statuses and logs
+	Name string `json:"name"`
+	// path of the file with the spells. If the source has an API, this is
+	// where the spells fetched from it are cached. Relative paths are relative
+	// to the directory of the config file
+	Local string `json:"local"`
+	// optional URL of the API from which the spells are fetched
+	API string `json:"api,omitempty"`
+	// format of the API responses, or of the local file if there is no API.
+	// Caches are always saved in the litch format.
+	Format string `json:"format,omitempty"`
+	// if multiple sources have a spell with the same index, the spell from
+	// the one with the highest priority is used
+	Priority int `json:"priority"`
+}
+
+// Config holds the app configuration which is loaded from ConfigFile
+type Config struct {
+	Sources []SourceConfig `json:"sources"`
+	// caches of APIs older than this are checked for changes in the
+	// background. Negative values disable the check
+	CacheMaxAgeDays int `json:"cache_max_age_days"`
+	// format in which caches are saved, json, gzip or gob. Caches in the
+	// other formats are migrated when they are loaded
+	CacheFormat string `json:"cache_format,omitempty"`
+	// slugs of documents whose spells are shown, all are shown if empty.
+	// Spells without a document are always shown
+	Documents []string `json:"documents,omitempty"`
+}
+
+// Returns the config used when there is no config file. It has custom spells
+// and spells from the Open5e API where custom spells take priority.
+func defaultConfig() *Config {
+	return &Config{
+		Sources: []SourceConfig{
+			{Name: "custom spells", Local: "local/spells.json", Format: "litch", Priority: 10},
+			{Name: "spreadsheet spells", Local: "local/spells.csv", Format: "csv", Priority: 10},
+			{Name: "remote spells", Local: "cache/spells.json", API: "https://api.open5e.com/spells/", Format: "open5e"},
+		},
+		CacheMaxAgeDays: 7,
+	}
+}
+
+// LoadConfig loads the config from the file. If the file doesn't exist, the
+// default config is returned. Nothing is written, the file is created only
+// once the config is saved.
+func LoadConfig(file string) (*Config, error) {
+	if !checkFile(file) {
+		return defaultConfig(), nil
+	}
+	// options missing from the file keep their default values
+	config := Config{CacheMaxAgeDays: defaultConfig().CacheMaxAgeDays}
+	if err := loadJSONFromFile(file, &config); err != nil {
+		return defaultConfig(), err
+	}
+	if len(config.Sources) == 0 {
+		config.Sources = defaultConfig().Sources
+	}
+	if err := config.validate(); err != nil {
+		return defaultConfig(), err
+	}
+	return &config, nil
+}
+
+// Save writes the config to the file
+func (c *Config) Save(file string) error {
+	if err := readyDir(path.Dir(file)); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+func (c *Config) validate() error {
+	if _, ok := cacheFormats[c.CacheFormat]; c.CacheFormat != "" && !ok {
+		return fmt.Errorf("unknown cache format: %s", c.CacheFormat)
+	}
+	names := map[string]bool{}
+	for i, src := range c.Sources {
+		if src.Name == "" {
+			return fmt.Errorf("source %d has no name", i+1)
+		}
+		if names[src.Name] {
+			return fmt.Errorf("source %s is listed twice", src.Name)
+		}
+		names[src.Name] = true
+		if src.Local == "" && src.API == "" {
+			return fmt.Errorf("source %s has neither a local path nor an API", src.Name)
+		}
+		format, ok := spellFormats[src.format()]
+		if !ok {
+			return fmt.Errorf("source %s has an unknown format: %s", src.Name, src.format())
+		}
+		if src.API != "" && format.fetch == nil {
+			return fmt.Errorf("format %s of source %s cannot be fetched from an API", src.format(), src.Name)
+		}
+	}
+	return nil
+}
+
+// CacheMaxAge returns the age after which caches are checked for changes
+func (c *Config) CacheMaxAge() time.Duration {
+	return time.Duration(c.CacheMaxAgeDays) * 24 * time.Hour
+}
+
+// DocumentEnabled reports whether spells from the document are shown
+func (c *Config) DocumentEnabled(slug string) bool {
+	if len(c.Documents) == 0 || slug == "" {
+		return true
+	}
+	for _, d := range c.Documents {
+		if d == slug {
+			return true
+		}
+	}
+	return false
+}
+
+// SortedSources returns the sources ordered by priority, highest first.
+// Sources with the same priority keep the order from the config.
+func (c *Config) SortedSources() []SourceConfig {
+	sources := append([]SourceConfig(nil), c.Sources...)
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].Priority > sources[j].Priority
+	})
+	return sources
+}
+
+// Returns the format of the source. If it isn't set, sources with an API are
+// open5e, local CSV and TSV files are recognised by their extension and
+// everything else is litch.
+func (src SourceConfig) format() string {
+	if src.Format != "" {
+		return src.Format
+	}
+	if src.API != "" {
+		return "open5e"
+	}
+	switch strings.ToLower(filepath.Ext(src.Local)) {
+	case ".csv":
+		return "csv"
+	case ".tsv":
+		return "tsv"
+	}
+	return "litch"
+}
+
+// Returns the absolute local path of the source, "" if there is none.
+// Relative paths are joined to dir.
+func (src SourceConfig) localPath(dir string) string {
+	if src.Local == "" || filepath.IsAbs(src.Local) {
+		return src.Local
+	}
+	return path.Join(dir, src.Local)
+}
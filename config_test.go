@@ -0,0 +1,145 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	var tests = []struct {
+		config  string
+		want    []string
+		wantErr bool
+	}{
+		// sources are listed by their names, ordered by priority
+		{"", []string{"custom spells", "spreadsheet spells", "remote spells"}, false},
+		{`{"sources": []}`, []string{"custom spells", "spreadsheet spells", "remote spells"}, false},
+		{`{"sources": [
+			{"name": "mirror", "local": "cache/mirror.json", "api": "http://localhost/spells/", "format": "open5e"},
+			{"name": "homebrew", "local": "/tmp/homebrew.json", "priority": 5},
+			{"name": "deep magic", "local": "local/deep.json", "format": "open5e", "priority": 5},
+			{"name": "custom", "local": "local/spells.json", "priority": 10}
+		]}`, []string{"custom", "homebrew", "deep magic", "mirror"}, false},
+		{`{"sources": [{"name": "mirror", "local": "cache/mirror.json", "api": "http://localhost/spells/"}]}`, []string{"mirror"}, false},
+		{`{"sources": [{"local": "local/spells.json"}]}`, nil, true},
+		{`{"sources": [{"name": "a", "local": "a.json"}, {"name": "a", "local": "b.json"}]}`, nil, true},
+		{`{"sources": [{"name": "a"}]}`, nil, true},
+		{`{"sources": [{"name": "a", "local": "a.json", "format": "xml"}]}`, nil, true},
+		{`{"sources": [{"name": "a", "api": "http://localhost/", "format": "litch"}]}`, nil, true},
+		{`{"sources": [`, nil, true},
+	}
+
+	for _, test := range tests {
+		file := t.TempDir() + "/config.json"
+		if test.config != "" {
+			if err := ioutil.WriteFile(file, []byte(test.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		config, err := LoadConfig(file)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Unexpected error for config %s: %v", test.config, err)
+			continue
+		}
+		if err != nil {
+			// the default config must be usable even if the file isn't
+			if !reflect.DeepEqual(config, defaultConfig()) {
+				t.Errorf("Expected the default config on error, but got: %#v", config)
+			}
+			continue
+		}
+		var names []string
+		for _, src := range config.SortedSources() {
+			names = append(names, src.Name)
+		}
+		if !reflect.DeepEqual(names, test.want) {
+			t.Errorf("Unexpected sources.\nhave: \"%v\"\nwant: \"%v\"", names, test.want)
+		}
+		// loading never writes the config
+		if test.config == "" && checkFile(file) {
+			t.Errorf("Config file was created on load")
+		}
+	}
+}
+
+func TestSourceFormat(t *testing.T) {
+	var tests = []struct {
+		src  SourceConfig
+		want string
+	}{
+		{SourceConfig{Local: "local/spells.json"}, "litch"},
+		{SourceConfig{Local: "local/homebrew.CSV"}, "csv"},
+		{SourceConfig{Local: "/tmp/homebrew.tsv"}, "tsv"},
+		{SourceConfig{Local: "local/homebrew.csv", Format: "litch"}, "litch"},
+		{SourceConfig{Local: "cache/spells.csv", API: "http://localhost/", Format: "open5e"}, "open5e"},
+		{SourceConfig{Local: "cache/spells.json", API: "http://localhost/"}, "open5e"},
+	}
+	for _, test := range tests {
+		if output := test.src.format(); output != test.want {
+			t.Errorf("Unexpected format of %v, expected %s, but got %s", test.src, test.want, output)
+		}
+	}
+}
+
+func TestSourceLocalPath(t *testing.T) {
+	var tests = []struct {
+		local string
+		want  string
+	}{
+		{"local/spells.json", ProjectDir + "/local/spells.json"},
+		{"/tmp/spells.json", "/tmp/spells.json"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if output := (SourceConfig{Local: test.local}).localPath(ProjectDir); output != test.want {
+			t.Errorf("Unexpected result, expected \"%s\", but got \"%s\"", test.want, output)
+		}
+	}
+}
+
+func TestMergeByPriority(t *testing.T) {
+	custom := Spells{ExampleSpells[2]}
+	custom[0].Name = "Custom Cone of Cold"
+	homebrew := Spells{ExampleSpells[0], ExampleSpells[2]}
+	remote := ExampleSpells
+
+	merged := &Spells{}
+	for _, s := range []Spells{custom, homebrew, remote} {
+		s := s
+		merged = mergeMultipleSources(merged, &s)
+	}
+	var names []string
+	for _, s := range *merged {
+		names = append(names, s.Name)
+	}
+	want := []string{"Acid Arrow", "Acid Splash", "Custom Cone of Cold", "Confusion"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Unexpected result.\nhave: \"%v\"\nwant: \"%v\"", names, want)
+	}
+}
+
+func TestConfigCacheMaxAge(t *testing.T) {
+	var tests = []struct {
+		config string
+		want   time.Duration
+	}{
+		{`{}`, 7 * 24 * time.Hour},
+		{`{"cache_max_age_days": 1}`, 24 * time.Hour},
+		{`{"cache_max_age_days": -1}`, -24 * time.Hour},
+	}
+	for _, test := range tests {
+		file := t.TempDir() + "/config.json"
+		if err := ioutil.WriteFile(file, []byte(test.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		config, err := LoadConfig(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output := config.CacheMaxAge(); output != test.want {
+			t.Errorf("Unexpected max age for %s, expected %v, but got %v", test.config, test.want, output)
+		}
+	}
+}
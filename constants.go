@@ -18,12 +18,46 @@ const (
 	EventErr       EventType = "ERR"
 	HlghtNormal    string    = "[white]"
 	HlghtSubstr    string    = "[#ff0000]"
+	// width of the gauge shown in the status box while fetching
+	progressGaugeWidth = 20
 )
 
 var CacheDir string = fmt.Sprintf("%s/cache", ProjectDir)
 var LocalDir string = fmt.Sprintf("%s/local", ProjectDir)
+var SpellbookDir string = fmt.Sprintf("%s/spellbooks", ProjectDir)
+var SlotDir string = fmt.Sprintf("%s/slots", ProjectDir)
+var ExportDir string = fmt.Sprintf("%s/exports", ProjectDir)
+var ConfigFile string = fmt.Sprintf("%s/config.json", ProjectDir)
 var LogFile string = fmt.Sprintf("%s/log.txt", ProjectDir)
 
+// Env holds the files of litch and the fetcher of API pages. The app and the
+// subcommands are given one, so tests can point them to their own dirs
+// without changing the globals above while something is being loaded.
+type Env struct {
+	ConfigFile   string
+	CacheDir     string
+	LocalDir     string
+	SpellbookDir string
+	SlotDir      string
+	ExportDir    string
+	LogFile      string
+	Pages        *PageFetcher
+}
+
+// Returns the env with files in ProjectDir and pages fetched from the APIs
+func defaultEnv() *Env {
+	return &Env{
+		ConfigFile:   ConfigFile,
+		CacheDir:     CacheDir,
+		LocalDir:     LocalDir,
+		SpellbookDir: SpellbookDir,
+		SlotDir:      SlotDir,
+		ExportDir:    ExportDir,
+		LogFile:      LogFile,
+		Pages:        NewPageFetcher(NewAPIClient()),
+	}
+}
+
 var ProjectDir string = func() string {
 	config, err := os.UserConfigDir()
 	if err != nil {
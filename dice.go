@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Limits of dice that are rolled, larger expressions are most likely typos
+const (
+	MaxDiceCount   = 100
+	MaxDiceSides   = 1000
+	MaxRollHistory = 50
+)
+
+// Dice is a dice expression like "8d6" or "1d10 + 4"
+type Dice struct {
+	Count    int
+	Sides    int
+	Modifier int
+	// a modifier that isn't a number, per example "your spellcasting
+	// ability modifier". It is shown with the result but not added to it
+	Note string
+	// the expression as it was written and its position in the text it was
+	// found in, in bytes
+	Text  string
+	Start int
+	End   int
+}
+
+// Returns the expression in the usual notation, per example "1d10+4"
+func (d Dice) String() string {
+	s := strconv.Itoa(d.Count) + "d" + strconv.Itoa(d.Sides)
+	if d.Modifier > 0 {
+		s += "+" + strconv.Itoa(d.Modifier)
+	} else if d.Modifier < 0 {
+		s += strconv.Itoa(d.Modifier)
+	}
+	if d.Note != "" {
+		s += " + " + d.Note
+	}
+	return s
+}
+
+var (
+	// dice in descriptions, optionally followed by a modifier like "+ 4" or
+	// "+ your spellcasting ability modifier"
+	diceRegexp = regexp.MustCompile(`(?i)\b(\d*)d(\d+)\b(?:\s*([+-])\s*(\d+\b|your spellcasting (?:ability )?modifier))?`)
+	// dice typed in by the user
+	diceInputRegexp = regexp.MustCompile(`(?i)^(\d*)d(\d+)(?:([+-])(\d+))?$`)
+)
+
+// Builds dice from submatches of diceRegexp or diceInputRegexp
+func diceFromMatch(m []string) (Dice, error) {
+	d := Dice{Count: 1}
+	if m[1] != "" {
+		d.Count, _ = strconv.Atoi(m[1])
+	}
+	d.Sides, _ = strconv.Atoi(m[2])
+	if m[4] != "" {
+		mod, err := strconv.Atoi(m[4])
+		if err != nil {
+			d.Note = m[4]
+		} else if m[3] == "-" {
+			d.Modifier = -mod
+		} else {
+			d.Modifier = mod
+		}
+	}
+	if d.Count < 1 || d.Count > MaxDiceCount {
+		return d, fmt.Errorf("Number of dice must be between 1 and %d", MaxDiceCount)
+	}
+	if d.Sides < 1 || d.Sides > MaxDiceSides {
+		return d, fmt.Errorf("Dice must have between 1 and %d sides", MaxDiceSides)
+	}
+	return d, nil
+}
+
+// Returns all dice expressions in the text in the order they appear.
+// Expressions that can't be rolled, like "0d6", are left out.
+func findDice(text string) []Dice {
+	var dice []Dice
+	for _, loc := range diceRegexp.FindAllStringSubmatchIndex(text, -1) {
+		m := make([]string, len(loc)/2)
+		for i := range m {
+			if loc[2*i] >= 0 {
+				m[i] = text[loc[2*i]:loc[2*i+1]]
+			}
+		}
+		d, err := diceFromMatch(m)
+		if err != nil {
+			continue
+		}
+		d.Text, d.Start, d.End = m[0], loc[0], loc[1]
+		dice = append(dice, d)
+	}
+	return dice
+}
+
+// Returns dice in the description of the spell and then in its at higher
+// levels description, numbered like in the widebox
+func spellDice(s *Spell) []Dice {
+	return append(findDice(s.Desc), findDice(s.HigherLevel)...)
+}
+
+// Parses a dice expression typed in by the user, per example "2d6+3".
+// Spaces are ignored.
+func parseDice(s string) (Dice, error) {
+	text := strings.Join(strings.Fields(s), "")
+	m := diceInputRegexp.FindStringSubmatch(text)
+	if m == nil {
+		return Dice{}, fmt.Errorf("Invalid dice: %s", s)
+	}
+	d, err := diceFromMatch(m)
+	d.Text = text
+	return d, err
+}
+
+// Colors dice in the text for tview and marks them with their numbers,
+// starting with first
+func highlightDice(text string, dice []Dice, first int) string {
+	var b strings.Builder
+	last := 0
+	for i, d := range dice {
+		b.WriteString(text[last:d.Start])
+		b.WriteString("[#55ccff]" + d.Text + "[gray]" + superscript(first+i) + "[white]")
+		last = d.End
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+var superscriptDigits = []rune("⁰¹²³⁴⁵⁶⁷⁸⁹")
+
+func superscript(n int) string {
+	var s []rune
+	for _, c := range strconv.Itoa(n) {
+		s = append(s, superscriptDigits[c-'0'])
+	}
+	return string(s)
+}
+
+// Roll is the result of rolling dice
+type Roll struct {
+	// what was rolled, per example the spell name. Can be empty
+	Label string
+	Dice  Dice
+	// results of single dice
+	Rolls []int
+	Total int
+}
+
+// Returns the result with its breakdown, per example
+// "Fireball 8d6: 3 + 5 + 1 + 6 + 2 + 4 + 4 + 2 = 27"
+func (r Roll) String() string {
+	var parts []string
+	for _, n := range r.Rolls {
+		parts = append(parts, strconv.Itoa(n))
+	}
+	breakdown := strings.Join(parts, " + ")
+	if r.Dice.Modifier > 0 {
+		breakdown += " + " + strconv.Itoa(r.Dice.Modifier)
+	} else if r.Dice.Modifier < 0 {
+		breakdown += " - " + strconv.Itoa(-r.Dice.Modifier)
+	}
+	s := r.Dice.String() + ": "
+	if r.Label != "" {
+		s = r.Label + " " + s
+	}
+	if len(r.Rolls) > 1 || r.Dice.Modifier != 0 {
+		s += breakdown + " = "
+	}
+	s += strconv.Itoa(r.Total)
+	if r.Dice.Note != "" {
+		s += " + " + r.Dice.Note
+	}
+	return s
+}
+
+// Roller rolls dice. Rolls are reproducible when it is seeded with the same
+// seed.
+type Roller struct {
+	rng *rand.Rand
+}
+
+func NewRoller(seed int64) *Roller {
+	return &Roller{rand.New(rand.NewSource(seed))}
+}
+
+// Seed makes the following rolls start over as if the roller was created
+// with the seed
+func (r *Roller) Seed(seed int64) {
+	r.rng.Seed(seed)
+}
+
+func (r *Roller) Roll(label string, d Dice) Roll {
+	roll := Roll{Label: label, Dice: d, Total: d.Modifier}
+	for i := 0; i < d.Count; i++ {
+		n := r.rng.Intn(d.Sides) + 1
+		roll.Rolls = append(roll.Rolls, n)
+		roll.Total += n
+	}
+	return roll
+}
+
+// RollHistory keeps the last MaxRollHistory rolls
+type RollHistory struct {
+	Rolls []Roll
+}
+
+func (h *RollHistory) Add(r Roll) {
+	h.Rolls = append(h.Rolls, r)
+	if len(h.Rolls) > MaxRollHistory {
+		h.Rolls = h.Rolls[len(h.Rolls)-MaxRollHistory:]
+	}
+}
+
+// Render renders the rolls for the widebox, the last one first
+func (h *RollHistory) Render() string {
+	var text string
+	for i := len(h.Rolls) - 1; i >= 0; i-- {
+		text += fmt.Sprintf("[orange]%d[white] %s\n", i+1, h.Rolls[i])
+	}
+	return text
+}
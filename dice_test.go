@@ -0,0 +1,119 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindDice(t *testing.T) {
+	var tests = []struct {
+		text string
+		want []string
+	}{
+		{"Each creature takes 8d6 fire damage", []string{"8d6"}},
+		{"You regain 1d10 + your spellcasting ability modifier hit points", []string{"1d10 + your spellcasting ability modifier"}},
+		{"The damage increases by 1d8 for each slot level above 3rd.", []string{"1d8"}},
+		{"Roll a d20 and add 2d4 - 1 to it", []string{"d20", "2d4 - 1"}},
+		{"Nothing to roll for this one, 0d6 and hold6d isn't dice", nil},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, d := range findDice(test.text) {
+			if test.text[d.Start:d.End] != d.Text {
+				t.Errorf("Position of %q doesn't match the text %q", d.Text, test.text)
+			}
+			got = append(got, d.Text)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Unexpected dice in %q, expected %q, but got %q", test.text, test.want, got)
+		}
+	}
+}
+
+func TestParseDice(t *testing.T) {
+	var tests = []struct {
+		in      string
+		want    Dice
+		wantErr bool
+	}{
+		{"8d6", Dice{Count: 8, Sides: 6, Text: "8d6"}, false},
+		{"d20", Dice{Count: 1, Sides: 20, Text: "d20"}, false},
+		{"2d6 + 3", Dice{Count: 2, Sides: 6, Modifier: 3, Text: "2d6+3"}, false},
+		{"1D8-1", Dice{Count: 1, Sides: 8, Modifier: -1, Text: "1D8-1"}, false},
+		{"1000d6", Dice{}, true},
+		{"2d0", Dice{}, true},
+		{"fireball", Dice{}, true},
+	}
+	for _, test := range tests {
+		got, err := parseDice(test.in)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Unexpected error for %q: %v", test.in, err)
+			continue
+		}
+		if !test.wantErr && got != test.want {
+			t.Errorf("Unexpected dice for %q, expected %+v, but got %+v", test.in, test.want, got)
+		}
+	}
+}
+
+func TestRoller(t *testing.T) {
+	d := Dice{Count: 8, Sides: 6, Modifier: 2}
+	first := NewRoller(42).Roll("Fireball", d)
+	if len(first.Rolls) != 8 {
+		t.Fatalf("Expected 8 rolls, but got %v", first.Rolls)
+	}
+	sum := d.Modifier
+	for _, n := range first.Rolls {
+		if n < 1 || n > 6 {
+			t.Errorf("Roll out of range: %d", n)
+		}
+		sum += n
+	}
+	if first.Total != sum {
+		t.Errorf("Expected the total to be %d, but got %d", sum, first.Total)
+	}
+
+	// the same seed gives the same rolls
+	r := NewRoller(1)
+	r.Roll("", d)
+	r.Seed(42)
+	if again := r.Roll("Fireball", d); !reflect.DeepEqual(again, first) {
+		t.Errorf("Expected reseeding to repeat %v, but got %v", first, again)
+	}
+}
+
+func TestRollString(t *testing.T) {
+	var tests = []struct {
+		roll Roll
+		want string
+	}{
+		{Roll{"Fireball", Dice{Count: 3, Sides: 6}, []int{1, 5, 6}, 12}, "Fireball 3d6: 1 + 5 + 6 = 12"},
+		{Roll{"", Dice{Count: 1, Sides: 20}, []int{17}, 17}, "1d20: 17"},
+		{Roll{"", Dice{Count: 1, Sides: 8, Modifier: -1}, []int{4}, 3}, "1d8-1: 4 - 1 = 3"},
+		{Roll{"Cure Wounds", Dice{Count: 1, Sides: 8, Note: "your spellcasting ability modifier"}, []int{6}, 6},
+			"Cure Wounds 1d8 + your spellcasting ability modifier: 6 + your spellcasting ability modifier"},
+	}
+	for _, test := range tests {
+		if got := test.roll.String(); got != test.want {
+			t.Errorf("Expected %q, but got %q", test.want, got)
+		}
+	}
+}
+
+func TestRollHistory(t *testing.T) {
+	var h RollHistory
+	for i := 0; i < MaxRollHistory+5; i++ {
+		h.Add(Roll{Total: i})
+	}
+	if len(h.Rolls) != MaxRollHistory || h.Rolls[0].Total != 5 {
+		t.Errorf("Expected the last %d rolls to be kept, but got %d starting with %d", MaxRollHistory, len(h.Rolls), h.Rolls[0].Total)
+	}
+}
+
+func TestHighlightDice(t *testing.T) {
+	text := "Take 2d6 or 1d10 damage"
+	want := "Take [#55ccff]2d6[gray]³[white] or [#55ccff]1d10[gray]⁴[white] damage"
+	if got := highlightDice(text, findDice(text), 3); got != want {
+		t.Errorf("Expected %q, but got %q", want, got)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A round of combat lasts 6 seconds
+const RoundDuration = 6 * time.Second
+
+// matches the first "<number> <unit>" in a duration like "Up to 10 minutes"
+var durationRegexp = regexp.MustCompile(`(?i)(\d+)\s*(round|minute|hour|day)s?\b`)
+
+// Parses a spell duration like "1 minute", "Up to 10 minutes" or "8 hours".
+// ok is false for durations that cannot be tracked, like "Instantaneous" or
+// "Until dispelled".
+func parseSpellDuration(duration string) (d time.Duration, ok bool) {
+	m := durationRegexp.FindStringSubmatch(duration)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	units := map[string]time.Duration{
+		"round":  RoundDuration,
+		"minute": time.Minute,
+		"hour":   time.Hour,
+		"day":    24 * time.Hour,
+	}
+	return time.Duration(n) * units[strings.ToLower(m[2])], true
+}
+
+// ActiveEffect is a spell that was cast and whose duration hasn't run out yet
+type ActiveEffect struct {
+	Name          string
+	Concentration bool
+	Start         time.Time
+	Duration      time.Duration
+}
+
+// Remaining returns the time that is left until the effect ends
+func (e *ActiveEffect) Remaining(now time.Time) time.Duration {
+	left := e.Start.Add(e.Duration).Sub(now)
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// Rounds returns the number of combat rounds that are left, rounded up
+func (e *ActiveEffect) Rounds(now time.Time) int {
+	left := e.Remaining(now)
+	return int((left + RoundDuration - 1) / RoundDuration)
+}
+
+// EffectTracker keeps track of active spells. A character can concentrate on
+// only one spell at a time, so activating a concentration spell ends the one
+// that was concentrated on before.
+type EffectTracker struct {
+	Effects []ActiveEffect
+}
+
+// Activate starts tracking the spell. If a concentration spell replaces
+// another one, the replaced spell is returned.
+func (t *EffectTracker) Activate(s *Spell, now time.Time) (replaced *ActiveEffect, err error) {
+	d, ok := parseSpellDuration(s.Duration)
+	if !ok {
+		return nil, fmt.Errorf("Duration of %s cannot be tracked: %s", s.Name, s.Duration)
+	}
+	if s.Concentration {
+		for i, e := range t.Effects {
+			if e.Concentration {
+				replaced = &e
+				t.Effects = append(t.Effects[:i], t.Effects[i+1:]...)
+				break
+			}
+		}
+	}
+	t.Effects = append(t.Effects, ActiveEffect{s.Name, s.Concentration, now, d})
+	return replaced, nil
+}
+
+// End ends the effect at the index
+func (t *EffectTracker) End(i int) (*ActiveEffect, error) {
+	if i < 0 || i >= len(t.Effects) {
+		return nil, fmt.Errorf("No active effect number %d", i+1)
+	}
+	e := t.Effects[i]
+	t.Effects = append(t.Effects[:i], t.Effects[i+1:]...)
+	return &e, nil
+}
+
+// Advance makes the effects skip the given number of rounds, per example
+// when rounds of combat are tracked instead of the real time
+func (t *EffectTracker) Advance(rounds int) {
+	for i := range t.Effects {
+		t.Effects[i].Start = t.Effects[i].Start.Add(-time.Duration(rounds) * RoundDuration)
+	}
+}
+
+// Expire removes the effects that have ended and returns them
+func (t *EffectTracker) Expire(now time.Time) []ActiveEffect {
+	var active, expired []ActiveEffect
+	for _, e := range t.Effects {
+		if e.Remaining(now) > 0 {
+			active = append(active, e)
+		} else {
+			expired = append(expired, e)
+		}
+	}
+	t.Effects = active
+	return expired
+}
+
+// Render renders the effects with countdowns for the effect panel
+func (t *EffectTracker) Render(now time.Time) string {
+	var text string
+	for i, e := range t.Effects {
+		name := e.Name
+		if e.Concentration {
+			name = "[yellow]C[white] " + name
+		}
+		text += fmt.Sprintf("[orange]%d[white] %s\n  %d rnd, %s\n", i+1, name, e.Rounds(now), formatCountdown(e.Remaining(now)))
+	}
+	return text
+}
+
+// Formats the duration as m:ss or h:mm:ss
+func formatCountdown(d time.Duration) string {
+	secs := int(d.Round(time.Second) / time.Second)
+	if secs >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", secs/3600, secs%3600/60, secs%60)
+	}
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
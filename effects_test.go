@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSpellDuration(t *testing.T) {
+	var tests = []struct {
+		duration string
+		want     time.Duration
+		ok       bool
+	}{
+		{"1 round", 6 * time.Second, true},
+		{"1 minute", time.Minute, true},
+		{"Up to 10 minutes", 10 * time.Minute, true},
+		{"Concentration, up to 1 hour", time.Hour, true},
+		{"8 hours", 8 * time.Hour, true},
+		{"10 days", 240 * time.Hour, true},
+		{"Instantaneous", 0, false},
+		{"Until dispelled", 0, false},
+		{"", 0, false},
+	}
+	for _, test := range tests {
+		d, ok := parseSpellDuration(test.duration)
+		if d != test.want || ok != test.ok {
+			t.Errorf("Unexpected result for \"%s\", expected %v %v, but got %v %v", test.duration, test.want, test.ok, d, ok)
+		}
+	}
+}
+
+func TestEffectTracker(t *testing.T) {
+	now := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	bless := Spell{Name: "Bless", Duration: "Up to 1 minute", Concentration: true}
+	hex := Spell{Name: "Hex", Duration: "Up to 1 hour", Concentration: true}
+	mageArmor := Spell{Name: "Mage Armor", Duration: "8 hours"}
+	fireball := Spell{Name: "Fireball", Duration: "Instantaneous"}
+
+	var tracker EffectTracker
+	if replaced, err := tracker.Activate(&bless, now); replaced != nil || err != nil {
+		t.Fatalf("Unexpected result of activating the first spell: %v %v", replaced, err)
+	}
+	tracker.Activate(&mageArmor, now)
+	if _, err := tracker.Activate(&fireball, now); err == nil {
+		t.Errorf("Activated an instantaneous spell")
+	}
+	replaced, err := tracker.Activate(&hex, now.Add(10*time.Second))
+	if err != nil || replaced == nil || replaced.Name != "Bless" {
+		t.Fatalf("Expected Hex to replace Bless, but got: %v %v", replaced, err)
+	}
+	if len(tracker.Effects) != 2 {
+		t.Fatalf("Expected 2 effects, but got %d", len(tracker.Effects))
+	}
+
+	// 10 seconds in, hex has 59:50 left which is 599 rounds rounded up
+	if rounds := tracker.Effects[1].Rounds(now.Add(20 * time.Second)); rounds != 599 {
+		t.Errorf("Expected 599 rounds, but got %d", rounds)
+	}
+	tracker.Advance(600)
+	expired := tracker.Expire(now.Add(10 * time.Second))
+	if len(expired) != 1 || expired[0].Name != "Hex" {
+		t.Errorf("Expected Hex to expire, but got: %v", expired)
+	}
+	if _, err := tracker.End(1); err == nil {
+		t.Errorf("Ended an effect that doesn't exist")
+	}
+	if e, err := tracker.End(0); err != nil || e.Name != "Mage Armor" {
+		t.Errorf("Expected Mage Armor to end, but got: %v %v", e, err)
+	}
+}
+
+func TestFormatCountdown(t *testing.T) {
+	var tests = []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0:00"},
+		{59*time.Second + 600*time.Millisecond, "1:00"},
+		{10 * time.Minute, "10:00"},
+		{8*time.Hour + 5*time.Second, "8:00:05"},
+	}
+	for _, test := range tests {
+		if output := formatCountdown(test.d); output != test.want {
+			t.Errorf("Unexpected result, expected \"%s\", but got \"%s\"", test.want, output)
+		}
+	}
+}
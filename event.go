@@ -1,6 +1,9 @@
 package main
 
-import "sync"
+import (
+	"fmt"
+	"sync"
+)
 
 // can be EventInfo, EventWarn or EventErr
 type EventType string
@@ -19,11 +22,14 @@ type EventRegister struct {
 	mu      sync.Mutex
 	logger  *Logger
 	channel chan string
+	// progress of fetches is sent here
+	progress chan FetchProgress
 }
 
-// NewEventRegister returns a pointer to a new EventRegister. Channel can be nil.
-func NewEventRegister(logger *Logger, channel chan string) *EventRegister {
-	r := EventRegister{sync.Mutex{}, logger, channel}
+// NewEventRegister returns a pointer to a new EventRegister. Channel and
+// progress can be nil.
+func NewEventRegister(logger *Logger, channel chan string, progress chan FetchProgress) *EventRegister {
+	r := EventRegister{sync.Mutex{}, logger, channel, progress}
 	return &r
 }
 
@@ -39,3 +45,14 @@ func (r *EventRegister) Register(evt EventType, logtext, chantext string) {
 		r.channel <- chantext
 	}
 }
+
+// Progress logs the progress of a fetch and sends it through the progress
+// channel
+func (r *EventRegister) Progress(p FetchProgress) {
+	r.mu.Lock()
+	r.logger.Log(EventInfo, fmt.Sprintf("fetching %s: %s", p.Source, p))
+	r.mu.Unlock()
+	if r.progress != nil {
+		r.progress <- p
+	}
+}
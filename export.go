@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ExportFormat writes spells in a format meant to be read by people or
+// imported by other tools
+type ExportFormat struct {
+	// extension of exported files, with the dot
+	Ext string
+	// writes spells, title names the whole set, per example a spellbook
+	write func(w io.Writer, title string, spells Spells) error
+}
+
+// All formats spells can be exported to by their names
+var exportFormats = map[string]*ExportFormat{
+	"markdown": {".md", writeMarkdown},
+	"html":     {".html", writeHTML},
+	// these can be imported back as spell sources
+	"csv": {".csv", writeSpellCSV},
+	"tsv": {".tsv", writeSpellTSV},
+	// virtual tabletops, described in vtt.go
+	"foundry": {".json", writeFoundry},
+	"roll20":  {".json", writeRoll20},
+}
+
+// Returns names of all export formats, sorted
+func exportFormatNames() []string {
+	var names []string
+	for name := range exportFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Exports spells in the named format to w
+func exportSpells(w io.Writer, format, title string, spells Spells) error {
+	f, ok := exportFormats[format]
+	if !ok {
+		return fmt.Errorf("Unknown export format: %s, expected one of %s", format, strings.Join(exportFormatNames(), ", "))
+	}
+	buf := bufio.NewWriter(w)
+	if err := f.write(buf, title, spells); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+// Exports spells in the named format to a file, creating its directory if it
+// doesn't exist
+func exportSpellsToFile(file, format, title string, spells Spells) error {
+	if _, ok := exportFormats[format]; !ok {
+		return fmt.Errorf("Unknown export format: %s, expected one of %s", format, strings.Join(exportFormatNames(), ", "))
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := exportSpells(f, format, title, spells); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Writes a section per spell laid out like the detail view: the name, the
+// level line, a table of casting time, range, components and duration, and
+// the description
+func writeMarkdown(w io.Writer, title string, spells Spells) error {
+	fmt.Fprintf(w, "# %s\n", title)
+	for i := range spells {
+		s := &spells[i]
+		fmt.Fprintf(w, "\n## %s\n\n*%s*", s.Name, levelSchool(s))
+		if tags := spellTags(s); len(tags) > 0 {
+			fmt.Fprintf(w, " · %s", strings.Join(tags, " · "))
+		}
+		fmt.Fprint(w, "\n\n| Casting Time | Range | Components | Duration |\n| --- | --- | --- | --- |\n")
+		cells := []string{s.CastingTime, s.Range, componentsText(s), s.Duration}
+		for i := range cells {
+			cells[i] = markdownCell(cells[i])
+		}
+		fmt.Fprintf(w, "| %s |\n\n", strings.Join(cells, " | "))
+		if classes := classNames(s); classes != "" {
+			fmt.Fprintf(w, "**Classes:** %s  \n", classes)
+		}
+		if source := sourceText(s); source != "" {
+			fmt.Fprintf(w, "**Source:** %s  \n", source)
+		}
+		fmt.Fprintf(w, "\n%s\n", strings.TrimSpace(s.Desc))
+		if s.HigherLevel != "" {
+			fmt.Fprintf(w, "\n**At higher levels:** %s\n", strings.TrimSpace(s.HigherLevel))
+		}
+	}
+	// write errors are returned by exportSpells when the buffer is flushed
+	return nil
+}
+
+// Escapes characters which would break a cell of a Markdown table
+var markdownCellReplacer = strings.NewReplacer("|", "\\|", "\n", " ")
+
+func markdownCell(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return markdownCellReplacer.Replace(s)
+}
+
+// Writes a self-contained page of spell cards which fits nicely on paper
+func writeHTML(w io.Writer, title string, spells Spells) error {
+	type card struct {
+		*Spell
+		LevelSchool string
+		Tags        []string
+		Components  string
+		Classes     string
+		Source      string
+	}
+	var cards []card
+	for i := range spells {
+		s := &spells[i]
+		cards = append(cards, card{s, levelSchool(s), spellTags(s), componentsText(s), classNames(s), sourceText(s)})
+	}
+	return htmlTemplate.Execute(w, struct {
+		Title string
+		Cards []card
+	}{title, cards})
+}
+
+var htmlTemplate = template.Must(template.New("cards").Funcs(template.FuncMap{"desc": descHTML}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: Georgia, serif; margin: 1em; color: #222; }
+h1 { font-size: 1.4em; }
+.cards { display: flex; flex-wrap: wrap; gap: 0.5em; }
+.card { box-sizing: border-box; width: 63mm; min-height: 88mm; padding: 3mm; border: 2px solid #7a200d; border-radius: 3mm; font-size: 8pt; break-inside: avoid; page-break-inside: avoid; }
+.card h2 { margin: 0; font-size: 11pt; color: #7a200d; }
+.level { font-style: italic; margin-bottom: 2mm; }
+.tag { font-style: normal; font-size: 7pt; border: 1px solid #7a200d; border-radius: 1mm; padding: 0 1mm; margin-left: 1mm; }
+.stats { display: grid; grid-template-columns: 1fr 1fr; gap: 1mm; margin-bottom: 2mm; }
+.stats div { background: #f3e9e2; padding: 1mm; }
+.stats b { display: block; font-size: 6.5pt; color: #7a200d; text-transform: uppercase; }
+.desc p { margin: 0 0 1.5mm; }
+.footer { border-top: 1px solid #7a200d; margin-top: 2mm; padding-top: 1mm; font-size: 6.5pt; color: #555; }
+@media print { body { margin: 0; } h1 { display: none; } }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="cards">
+{{- range .Cards}}
+<div class="card" id="{{.Index}}">
+<h2>{{.Name}}</h2>
+<div class="level">{{.LevelSchool}}{{range .Tags}}<span class="tag">{{.}}</span>{{end}}</div>
+<div class="stats">
+<div><b>Casting Time</b>{{.CastingTime}}</div>
+<div><b>Range</b>{{.Range}}</div>
+<div><b>Components</b>{{.Components}}</div>
+<div><b>Duration</b>{{.Duration}}</div>
+</div>
+<div class="desc">{{desc .Desc ""}}{{if .HigherLevel}}{{desc .HigherLevel "At higher levels:"}}{{end}}</div>
+{{- if or .Classes .Source}}
+<div class="footer">{{.Classes}}{{if and .Classes .Source}} · {{end}}{{.Source}}</div>
+{{- end}}
+</div>
+{{- end}}
+</div>
+</body>
+</html>
+`))
+
+var (
+	markdownBold   = regexp.MustCompile(`\*\*(.+?)\*\*`)
+	markdownItalic = regexp.MustCompile(`\*(.+?)\*`)
+)
+
+// Turns a description into HTML paragraphs, lead is shown in bold at the
+// start of the first one. Descriptions are Markdown, but only bold and italic
+// text is kept, the rest is shown as it is.
+func descHTML(text, lead string) template.HTML {
+	var html string
+	for i, p := range strings.Split(strings.TrimSpace(text), "\n\n") {
+		p = template.HTMLEscapeString(strings.TrimSpace(p))
+		p = markdownBold.ReplaceAllString(p, "<b>$1</b>")
+		p = markdownItalic.ReplaceAllString(p, "<i>$1</i>")
+		if i == 0 && lead != "" {
+			p = "<b>" + template.HTMLEscapeString(lead) + "</b> " + p
+		}
+		html += "<p>" + strings.Replace(p, "\n", "<br>", -1) + "</p>"
+	}
+	return template.HTML(html)
+}
+
+// Returns the level and the school like the detail view shows them, per
+// example "Level 2 Evocation" or "Conjuration Cantrip"
+func levelSchool(s *Spell) string {
+	if s.Level == 0 {
+		return strings.TrimSpace(s.School.Name + " Cantrip")
+	}
+	return strings.TrimSpace("Level " + strconv.Itoa(s.Level) + " " + s.School.Name)
+}
+
+// Returns C for concentration and R for ritual spells like the spell list
+func spellFlags(s *Spell) string {
+	var flags string
+	if s.Concentration {
+		flags += "C"
+	}
+	if s.Ritual {
+		flags += "R"
+	}
+	return flags
+}
+
+// Returns Ritual and Concentration tags like the detail view shows them
+func spellTags(s *Spell) []string {
+	var tags []string
+	if s.Ritual {
+		tags = append(tags, "Ritual")
+	}
+	if s.Concentration {
+		tags = append(tags, "Concentration")
+	}
+	return tags
+}
+
+// Returns components with the material in parentheses, per example
+// "V, S, M (a feather)"
+func componentsText(s *Spell) string {
+	text := strings.Join(s.Components, ", ")
+	if s.Material != "" {
+		text += " (" + s.Material + ")"
+	}
+	return strings.TrimSpace(text)
+}
+
+// Returns classes and subclasses separated by commas
+func classNames(s *Spell) string {
+	var names []string
+	for _, c := range s.Classes {
+		names = append(names, c.Name)
+	}
+	for _, c := range s.Subclasses {
+		names = append(names, c.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Returns the document and the page the spell is from like the detail view
+// shows them, per example "Systems Reference Document, phb 259"
+func sourceText(s *Spell) string {
+	source := s.Document.Title
+	if source == "" {
+		source = s.Document.Slug
+	}
+	if s.Page != "" && source != "" {
+		source += ", "
+	}
+	return source + s.Page
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteMarkdown(t *testing.T) {
+	var output bytes.Buffer
+	if err := exportSpells(&output, "markdown", "Spells", ExampleSpells[:1]); err != nil {
+		t.Fatal(err)
+	}
+	want := `# Spells
+
+## Acid Arrow
+
+*Level 2 Evocation*
+
+| Casting Time | Range | Components | Duration |
+| --- | --- | --- | --- |
+| 1 action | 90 feet | V, S, M | - |
+
+**Classes:** Druid, Wizard, Druid (Swamp)  
+**Source:** wotc-srd, phb 259  
+
+Green arrow
+`
+	if output.String() != want {
+		t.Errorf("Unexpected result.\nhave:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	spells := Spells{ExampleSpells[3], {Index: "x", Name: "<script>", Range: "a | b", Desc: "**Bold** and *italic*\n\n<b>not bold</b>"}}
+	var output bytes.Buffer
+	if err := exportSpells(&output, "html", "My <Book>", spells); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<title>My &lt;Book&gt;</title>",
+		`<div class="level">Level 4<span class="tag">Concentration</span></div>`,
+		"<div><b>Components</b>(Three walnut shells.)</div>",
+		"<p>Twists minds</p><p><b>At higher levels:</b> 5 feet</p>",
+		"<div class=\"footer\">Bard, Druid, Cleric (Knowledge) · wotc-srd, phb 224</div>",
+		"<h2>&lt;script&gt;</h2>",
+		"<p><b>Bold</b> and <i>italic</i></p><p>&lt;b&gt;not bold&lt;/b&gt;</p>",
+	} {
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("Expected the page to contain %q, but got:\n%s", want, output.String())
+		}
+	}
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	if err := exportSpells(&bytes.Buffer{}, "pdf", "Spells", ExampleSpells); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+}
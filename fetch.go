@@ -1,52 +1,270 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
 
 func (app *App) FetchData(isForce bool) {
-    defer func () {
-        if r := recover(); r != nil {
-            app.eventReg.Register(EventErr, fmt.Sprintf("Could not load spells: %v", r), "Unforseen error while fetching spells, check logs")
-        }
-    }()
+	defer func() {
+		if r := recover(); r != nil {
+			app.eventReg.Register(EventErr, fmt.Sprintf("Could not load spells: %v", r), "Unforseen error while fetching spells, check logs")
+		}
+	}()
 	// app.fetchLock keeps track whether data is being fetched at the moment.
 	// it will only be fetched if that isn't happening already. The actual
 	// fetching is in the separate method so that no routines are created
 	// unnecesarily, although that is of a little importance tbh.
-	if !app.fetchLock {
-		go app.fetchAllData(isForce)
-		// update the lock. It will be released when data is received
-		// through app.dataChan channel in app.waitForData method
-		app.fetchLock = true
+	app.fetchMu.Lock()
+	defer app.fetchMu.Unlock()
+	ctx, ok := app.lockFetch()
+	if !ok {
+		return
+	}
+	// the fetch gets its own copy so the env can't change under it. The lock
+	// will be released when data is received through app.dataChan channel in
+	// app.waitForData method, or when the fetch is cancelled
+	env := *app.env
+	go app.fetchAllData(ctx, &env, isForce, app.configChanges)
+}
+
+// Takes the fetch lock unless it is taken already and returns the context of
+// the new fetch. app.fetchMu must be held.
+func (app *App) lockFetch() (context.Context, bool) {
+	if app.fetchLock {
+		return nil, false
+	}
+	ctx, cancel := context.WithCancel(app.ctx)
+	app.fetchCancel = cancel
+	app.fetchLock = true
+	return ctx, true
+}
+
+// Takes the fetch lock for work that writes caches outside of a fetch, so it
+// can't write them while a fetch does. Returns false if a fetch is running.
+func (app *App) tryFetchLock() (context.Context, bool) {
+	app.fetchMu.Lock()
+	defer app.fetchMu.Unlock()
+	return app.lockFetch()
+}
+
+// CancelFetch cancels the fetch that is in progress. The spells that were
+// loaded before stay as they are. Returns false if nothing is being fetched.
+func (app *App) CancelFetch() bool {
+	app.fetchMu.Lock()
+	defer app.fetchMu.Unlock()
+	if !app.fetchLock || app.fetchCancel == nil {
+		return false
 	}
+	app.fetchCancel()
+	return true
 }
 
-func (app *App) fetchAllData(isForce bool) {
+// Returns whether spells are being fetched atm
+func (app *App) fetching() bool {
+	app.fetchMu.Lock()
+	defer app.fetchMu.Unlock()
+	return app.fetchLock
+}
+
+// Releases the fetch lock once the fetch has ended, so that spells can be
+// fetched again
+func (app *App) endFetch() {
+	app.fetchMu.Lock()
+	defer app.fetchMu.Unlock()
+	if app.fetchCancel != nil {
+		app.fetchCancel()
+	}
+	app.fetchCancel = nil
+	app.fetchLock = false
+}
+
+func (app *App) fetchAllData(ctx context.Context, env *Env, isForce bool, configChanges int) {
+	done := false
+	var failed []string
+	var status string
 	defer func() {
-		app.eventReg.Register(EventInfo, "Loaded spells sucessfully", "Done")
+		if done && len(failed) > 0 {
+			warn := fmt.Sprintf("Could not refetch %s, kept the previous spells", strings.Join(failed, ", "))
+			app.eventReg.Register(EventWarn, warn, warn)
+		} else if done {
+			app.eventReg.Register(EventInfo, "Loaded spells sucessfully", status)
+		}
 	}()
 
-	app.eventReg.Register(EventInfo, "Started loading spells...", "Loading spells...")
+	result, err := loadAllSpells(ctx, env, isForce, app.eventReg, app.sourceSpells)
+	// nothing that was fetched is used, so the app stays as it was
+	if err != nil {
+		app.eventReg.Register(EventWarn, "Fetching of spells was cancelled", "Cancelled")
+		app.endFetch()
+		return
+	}
+	failed = result.failed
+	result.configChanges = configChanges
+	// the ages of caches change once they are revalidated
+	status = doneStatus(result.fetchers, time.Now())
+
+	if app.sourceSpells == nil {
+		app.sourceSpells = map[string]Spells{}
+	}
+	for _, f := range result.fetchers {
+		if f.err == nil {
+			app.sourceSpells[f.name] = *f.data
+		}
+	}
+
+	app.dataChan <- result
+	done = true
+}
+
+// Returns the status shown once all spells are loaded. If any of them were
+// loaded from caches, the age of the oldest cache is kept in it, since it is
+// shown only briefly while loading.
+func doneStatus(fetchers []*SpellFetcher, now time.Time) string {
+	var oldest time.Duration
+	var cached int
+	for _, f := range fetchers {
+		if !f.fromCache {
+			continue
+		}
+		cached++
+		if age := cacheAge(f.fetched, now); age > oldest {
+			oldest = age
+		}
+	}
+	switch {
+	case cached == 0:
+		return "Done"
+	case cached == 1:
+		return fmt.Sprintf("Done (cache is %s)", formatAge(oldest))
+	}
+	return fmt.Sprintf("Done (oldest cache is %s)", formatAge(oldest))
+}
+
+// spellLoad is everything that is loaded by loadAllSpells
+type spellLoad struct {
+	// merged spells of all sources
+	spells Spells
+	config *Config
+	// the error of loading the config, config is the default one if it is set
+	configErr error
+	// changes of the config in the app when the fetch started, see
+	// App.configChanges
+	configChanges int
+	fetchers      []*SpellFetcher
+	diagnostics   []Diagnostic
+	// names of sources whose fetch failed
+	failed []string
+}
+
+// Loads the config and spells of all its sources from the files
+// of env, and merges the spells. Sources whose fetch failed and have no cache
+// get their spells from previous, which can be nil. An error is returned only
+// if ctx is done, in which case nothing that was loaded should be used.
+func loadAllSpells(ctx context.Context, env *Env, isForce bool, eventReg *EventRegister, previous map[string]Spells) (*spellLoad, error) {
+	eventReg.Register(EventInfo, "Started loading spells...", "Loading spells...")
 
 	// check if these exist, make them it they dont
-	readyDir(CacheDir)
-	readyDir(LocalDir)
+	readyDir(env.CacheDir)
+	readyDir(env.LocalDir)
 
-	tempSpellChan := make(chan Spells, 2)
+	config, configErr := LoadConfig(env.ConfigFile)
+	if configErr != nil {
+		eventReg.Register(EventErr, fmt.Sprintf("error while loading config: %s", configErr), "Could not load config, using the default one, check logs")
+	}
 
-	custom := NewSpellFetcher("custom spells", LocalDir+"/spells.json", "", app.eventReg)
-	api := NewSpellFetcher("remote spells", CacheDir+"/spells.json", "https://api.open5e.com/spells/", app.eventReg)
+	sources := config.SortedSources()
+	tempSpellChan := make(chan Spells, len(sources))
+	var fetchers []*SpellFetcher
+	for _, src := range sources {
+		f := NewSpellFetcher(src.Name, src.localPath(path.Dir(env.ConfigFile)), src.API, src.format(), env.Pages, eventReg)
+		f.cacheFormat = config.CacheFormat
+		fetchers = append(fetchers, f)
+		go f.FetchSpells(ctx, tempSpellChan, isForce)
+	}
+
+	// Synchronise fetching of all sources
+	for range fetchers {
+		<-tempSpellChan
+	}
+	result := spellLoad{config: config, configErr: configErr, fetchers: fetchers}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	custom.FetchSpells(tempSpellChan, isForce)
-	api.FetchSpells(tempSpellChan, isForce)
+	// sources whose fetch failed keep the spells they had before, even if
+	// there was no cache to fall back to
+	for _, f := range fetchers {
+		if f.err == nil {
+			continue
+		}
+		result.failed = append(result.failed, f.name)
+		if prev, ok := previous[f.name]; ok && len(*f.data) == 0 {
+			*f.data = prev
+		}
+	}
 
-	// Synchronise fetching of custom and API spells
-	<-tempSpellChan
-	<-tempSpellChan
+	for _, f := range fetchers {
+		result.diagnostics = append(result.diagnostics, f.diagnostics...)
+	}
 
-	app.eventReg.Register(EventInfo, "Merging spells...", "")
-	allSpells := mergeMultipleSources(custom.data, api.data)
+	eventReg.Register(EventInfo, "Merging spells...", "")
+	// fetchers are ordered by priority, so spells merged earlier win
+	allSpells := &Spells{}
+	for _, f := range fetchers {
+		allSpells = mergeMultipleSources(allSpells, f.data)
+	}
+	result.spells = *allSpells
+	return &result, nil
+}
 
-	app.dataChan <- *allSpells
+// Checks whether stale caches are still up to date with their APIs. If any
+// of them isn't, all spells are refetched. Up to date caches are rewritten,
+// so this holds the fetch lock and is skipped if a fetch is already running.
+func (app *App) revalidateCaches(fetchers []*SpellFetcher, maxAge time.Duration) {
+	now := time.Now()
+	var stale []*SpellFetcher
+	for _, f := range fetchers {
+		if f.Stale(maxAge, now) {
+			stale = append(stale, f)
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+	ctx, ok := app.tryFetchLock()
+	if !ok {
+		return
+	}
+	refetch := false
+	for _, f := range stale {
+		changed, err := f.Revalidate(ctx)
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			app.eventReg.Register(EventErr, fmt.Sprintf("error while checking %s for changes: %s", f.name, err), "")
+			continue
+		}
+		if !changed {
+			app.eventReg.Register(EventInfo, fmt.Sprintf("Cache of %s is up to date", f.name), "")
+			continue
+		}
+		info := fmt.Sprintf("%s have changed, refetching...", f.name)
+		app.eventReg.Register(EventInfo, info, info)
+		refetch = true
+		break
+	}
+	// the refetch takes the lock again
+	app.endFetch()
+	if refetch {
+		app.app.QueueUpdate(func() {
+			app.FetchData(true)
+		})
+	}
 }
 
 // Merge two spell lists alphabetically. It assumes that both lists are already
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestMergeMultipleSources(t *testing.T) {
@@ -34,3 +38,142 @@ func TestMergeMultipleSources(t *testing.T) {
 		}
 	}
 }
+
+func TestDoneStatus(t *testing.T) {
+	now := time.Now()
+	fetched := func(days int) *SpellFetcher {
+		return &SpellFetcher{fromCache: true, fetched: now.Add(-time.Duration(days) * 24 * time.Hour)}
+	}
+	var tests = []struct {
+		fetchers []*SpellFetcher
+		want     string
+	}{
+		{nil, "Done"},
+		{[]*SpellFetcher{{}}, "Done"},
+		{[]*SpellFetcher{{}, fetched(3)}, "Done (cache is 3 days old)"},
+		{[]*SpellFetcher{fetched(1), fetched(10)}, "Done (oldest cache is 10 days old)"},
+		{[]*SpellFetcher{{fromCache: true}}, "Done (cache is of unknown age)"},
+	}
+	for _, test := range tests {
+		if got := doneStatus(test.fetchers, now); got != test.want {
+			t.Errorf("Expected %q, but got %q", test.want, got)
+		}
+	}
+}
+
+func TestCancelFetch(t *testing.T) {
+	dir := t.TempDir()
+	env := testEnv(dir, NewPageFetcher(NewAPIClient()))
+
+	requested := make(chan bool, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- true
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	config := Config{Sources: []SourceConfig{{Name: "slow spells", Local: dir + "/cache/slow.json", API: server.URL, Format: "open5e"}}}
+	if err := config.Save(env.ConfigFile); err != nil {
+		t.Fatal(err)
+	}
+
+	spells := Spells{ExampleSpells[0]}
+	app := &App{spells: &spells, dataChan: make(chan *spellLoad, 1), env: env}
+	app.ctx, app.cancel = context.WithCancel(context.Background())
+	defer app.cancel()
+	app.eventReg = NewEventRegister(AppTest.eventReg.logger, nil, nil)
+
+	if app.CancelFetch() {
+		t.Errorf("Expected nothing to cancel before fetching")
+	}
+	app.FetchData(true)
+	<-requested
+	if !app.CancelFetch() {
+		t.Fatalf("Expected the fetch to be cancelled")
+	}
+	for start := time.Now(); app.fetching(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("The lock was not released after cancelling")
+		}
+	}
+	select {
+	case <-app.dataChan:
+		t.Errorf("Expected no spells to be sent after cancelling")
+	default:
+	}
+	if !reflect.DeepEqual(*app.spells, Spells{ExampleSpells[0]}) {
+		t.Errorf("Expected the loaded spells to stay untouched, but got %v", *app.spells)
+	}
+}
+
+func TestFailedRefetchKeepsSpells(t *testing.T) {
+	dir := t.TempDir()
+	env := testEnv(dir, NewPageFetcher(newTestClient()))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	// there is no cache to fall back to
+	config := Config{Sources: []SourceConfig{{Name: "remote spells", Local: dir + "/cache/spells.json", API: server.URL, Format: "open5e"}}}
+	if err := config.Save(env.ConfigFile); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &App{dataChan: make(chan *spellLoad, 1), env: env}
+	app.ctx, app.cancel = context.WithCancel(context.Background())
+	defer app.cancel()
+	app.eventReg = NewEventRegister(AppTest.eventReg.logger, nil, nil)
+	app.sourceSpells = map[string]Spells{"remote spells": ExampleSpells}
+
+	app.FetchData(true)
+	select {
+	case load := <-app.dataChan:
+		if !reflect.DeepEqual(load.spells, ExampleSpells) {
+			t.Errorf("Expected the previous spells, but got %v", load.spells)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No spells were sent")
+	}
+	if checkFile(dir + "/cache/spells.json") {
+		t.Errorf("Expected the failed fetch not to be cached")
+	}
+}
+
+func TestRevalidateSkipsRunningFetch(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer server.Close()
+
+	file := t.TempDir() + "/spells.json"
+	eventReg := NewEventRegister(AppTest.eventReg.logger, nil, nil)
+	f := NewSpellFetcher("remote spells", file, server.URL, "open5e", NewPageFetcher(NewAPIClient()), eventReg)
+	f.fetched, f.fromCache = time.Now().Add(-10*24*time.Hour), true
+	f.validators.ETag = `"v1"`
+	maxAge := 7 * 24 * time.Hour
+
+	app := &App{eventReg: eventReg}
+	app.ctx, app.cancel = context.WithCancel(context.Background())
+	defer app.cancel()
+
+	// a running fetch writes the caches itself
+	app.fetchLock = true
+	app.revalidateCaches([]*SpellFetcher{f}, maxAge)
+	if requests != 0 || checkFile(file) {
+		t.Errorf("Expected no revalidation while fetching")
+	}
+	app.endFetch()
+
+	app.revalidateCaches([]*SpellFetcher{f}, maxAge)
+	if requests != 1 || !checkFile(file) {
+		t.Errorf("Expected the cache to be revalidated, got %d requests", requests)
+	}
+	if app.fetching() {
+		t.Errorf("Expected the fetch lock to be released after revalidating")
+	}
+}
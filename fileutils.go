@@ -2,9 +2,10 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
+	"unicode"
 )
 
 // This file contains functions that are wrappers around
@@ -23,12 +24,12 @@ func checkFile(file string) bool {
 	return true
 }
 
-// Check if dir exists, make it if it doesn't.
+// Check if dir exists, make it if it doesn't. Nothing is printed, stdout
+// belongs to the output of the CLI.
 func readyDir(dir string) error {
 	_, err := os.Stat(dir)
 	if os.IsNotExist(err) {
-		fmt.Println(dir, "does not exist, creating...")
-		os.MkdirAll(dir, 0755)
+		return os.MkdirAll(dir, 0755)
 	}
 	if !os.IsNotExist(err) && err != nil {
 		return err
@@ -56,3 +57,14 @@ func loadJSONFromFile(file string, dest interface{}) error {
 
 	return nil
 }
+
+// Turn a name into a lowercase file name. Characters that could cause trouble
+// in file names are replaced with dashes.
+func safeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, name)
+}
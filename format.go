@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+)
+
+// SpellFormat is an adapter between a format in which spells are stored or
+// served and Spells
+type SpellFormat struct {
+	// decodes a local file in this format. Problems with single spells are
+	// returned as diagnostics, such spells are skipped
+	decode func(file string, data []byte, dest *Spells) ([]Diagnostic, error)
+	// fetches all spells from an API in this format, nil if the format is
+	// not served by any API. Pages are fetched with pages. Returns cache
+	// validators of the response. progress is called after each fetched
+	// page, it can be nil
+	fetch func(ctx context.Context, pages *PageFetcher, url string, dest *Spells, progress func(FetchProgress)) (Validators, error)
+}
+
+// All supported formats of spell sources by their names in the config
+var spellFormats = map[string]*SpellFormat{
+	// the format of caches and custom spells, described in README
+	"litch": {
+		decode: func(file string, data []byte, dest *Spells) ([]Diagnostic, error) {
+			spells, diags := validateSpells(file, data)
+			*dest = spells
+			return diags, nil
+		},
+	},
+	// the format of the Open5e API. Local files can contain a single page of
+	// the API or just an array of its results
+	"open5e": {
+		decode: func(file string, data []byte, dest *Spells) ([]Diagnostic, error) {
+			var results []SpellTemp
+			if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+				var page SpellAPI
+				if err := json.Unmarshal(data, &page); err != nil {
+					return nil, err
+				}
+				results = page.Results
+			} else if err := json.Unmarshal(data, &results); err != nil {
+				return nil, err
+			}
+			*dest = *spellAPIToStandard(&results)
+			return nil, nil
+		},
+		fetch: fetchSpells,
+	},
+	// spreadsheets, described in spelltable.go
+	"csv": {
+		decode: func(file string, data []byte, dest *Spells) ([]Diagnostic, error) {
+			spells, diags := decodeSpellCSV(file, data)
+			*dest = spells
+			return diags, nil
+		},
+	},
+	"tsv": {
+		decode: func(file string, data []byte, dest *Spells) ([]Diagnostic, error) {
+			spells, diags := decodeSpellTSV(file, data)
+			*dest = spells
+			return diags, nil
+		},
+	},
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BM25 ranking parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// SearchIndex is an in-memory inverted index over descriptions and at higher
+// levels descriptions of spells. Words are lowercased and stemmed before
+// they are indexed, so "frightened" also finds "frighten" and "frightening".
+type SearchIndex struct {
+	// stem -> documents (spells) it occurs in, ordered by the document
+	postings map[string][]posting
+	docs     []indexedDoc
+	avgLen   float64
+}
+
+// occurences of a stem in a single document
+type posting struct {
+	doc       int
+	positions []int
+}
+
+type indexedDoc struct {
+	text   string
+	tokens []indexedToken
+}
+
+// a single word of a document, start and end are byte offsets in the text
+type indexedToken struct {
+	stem       string
+	start, end int
+}
+
+// SearchHit is a single spell found by SearchIndex.Search. Doc is the index
+// of the spell in the slice the index was built from.
+type SearchHit struct {
+	Doc     int
+	Score   float64
+	Snippet string
+}
+
+// NewSearchIndex builds an index of the given spells. Doc in SearchHits
+// returned by the index is the index of a spell in this slice.
+func NewSearchIndex(spells Spells) *SearchIndex {
+	idx := SearchIndex{postings: map[string][]posting{}}
+	var total int
+	for i, s := range spells {
+		text := s.Desc
+		if s.HigherLevel != "" {
+			text += "\n\n" + s.HigherLevel
+		}
+		doc := indexedDoc{text: text, tokens: tokenize(text)}
+		positions := map[string][]int{}
+		for pos, t := range doc.tokens {
+			positions[t.stem] = append(positions[t.stem], pos)
+		}
+		for stem, p := range positions {
+			idx.postings[stem] = append(idx.postings[stem], posting{i, p})
+		}
+		idx.docs = append(idx.docs, doc)
+		total += len(doc.tokens)
+	}
+	if len(spells) > 0 {
+		idx.avgLen = float64(total) / float64(len(spells))
+	}
+	return &idx
+}
+
+// Search finds spells that contain all words and "quoted phrases" of the
+// query, ordered by relevance. Each hit has a snippet of the sentence with
+// the first match in which matched words are highlighted.
+func (idx *SearchIndex) Search(query string) ([]SearchHit, error) {
+	phrases, err := parseFullTextQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if idx == nil || len(phrases) == 0 {
+		return nil, nil
+	}
+
+	// doc -> first matched position and the score so far. Only documents
+	// that match every phrase survive.
+	type candidate struct {
+		first int
+		score float64
+	}
+	var candidates map[int]*candidate
+	for i, phrase := range phrases {
+		counts, firsts := idx.matchPhrase(phrase)
+		idf := idx.idf(len(counts))
+		next := map[int]*candidate{}
+		for doc, count := range counts {
+			c, ok := candidates[doc]
+			if i == 0 {
+				c, ok = &candidate{first: firsts[doc]}, true
+			}
+			if !ok {
+				continue
+			}
+			c.score += idf * idx.bm25(doc, count)
+			next[doc] = c
+		}
+		candidates = next
+	}
+
+	stems := map[string]bool{}
+	for _, phrase := range phrases {
+		for _, stem := range phrase {
+			stems[stem] = true
+		}
+	}
+	var hits []SearchHit
+	for doc, c := range candidates {
+		hits = append(hits, SearchHit{doc, c.score, idx.snippet(doc, c.first, stems)})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Doc < hits[j].Doc
+	})
+	return hits, nil
+}
+
+// Returns in how many places each document contains the phrase and the
+// position of the first one. A phrase of a single stem is just a word.
+func (idx *SearchIndex) matchPhrase(phrase []string) (counts, firsts map[int]int) {
+	counts, firsts = map[int]int{}, map[int]int{}
+	for _, p := range idx.postings[phrase[0]] {
+		tokens := idx.docs[p.doc].tokens
+		for _, pos := range p.positions {
+			if pos+len(phrase) > len(tokens) {
+				continue
+			}
+			matched := true
+			for k := 1; k < len(phrase); k++ {
+				if tokens[pos+k].stem != phrase[k] {
+					matched = false
+					break
+				}
+			}
+			if !matched {
+				continue
+			}
+			if counts[p.doc] == 0 {
+				firsts[p.doc] = pos
+			}
+			counts[p.doc]++
+		}
+	}
+	return counts, firsts
+}
+
+func (idx *SearchIndex) idf(docFreq int) float64 {
+	n := float64(len(idx.docs))
+	df := float64(docFreq)
+	return math.Log(1 + (n-df+0.5)/(df+0.5))
+}
+
+func (idx *SearchIndex) bm25(doc, count int) float64 {
+	tf := float64(count)
+	norm := 1 - bm25B + bm25B*float64(len(idx.docs[doc].tokens))/idx.avgLen
+	return tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+}
+
+// Returns the sentence around the token at pos with the words whose stems
+// are in stems highlighted
+func (idx *SearchIndex) snippet(doc, pos int, stems map[string]bool) string {
+	d := idx.docs[doc]
+	at := d.tokens[pos].start
+	start := strings.LastIndexAny(d.text[:at], ".!?\n") + 1
+	end := strings.IndexAny(d.text[at:], ".!?\n")
+	if end < 0 {
+		end = len(d.text)
+	} else {
+		end += at + 1
+	}
+
+	mask := make([]bool, end-start)
+	for _, t := range d.tokens {
+		if t.start >= start && t.end <= end && stems[t.stem] {
+			for i := t.start; i < t.end; i++ {
+				mask[i-start] = true
+			}
+		}
+	}
+	// leading whitespace is trimmed manually so that the mask stays aligned
+	text := d.text[start:end]
+	trimmed := strings.TrimLeftFunc(text, unicode.IsSpace)
+	mask = mask[len(text)-len(trimmed):]
+	return highlightMask(trimmed, mask)
+}
+
+// Splits the query into phrases. Every word outside quotes is a phrase of
+// its own.
+func parseFullTextQuery(query string) ([][]string, error) {
+	var phrases [][]string
+	parts := strings.Split(query, "\"")
+	if len(parts)%2 == 0 {
+		return nil, fmt.Errorf("Unclosed quote")
+	}
+	for i, part := range parts {
+		var stems []string
+		for _, t := range tokenize(part) {
+			stems = append(stems, t.stem)
+		}
+		// odd parts are between quotes
+		if i%2 == 1 {
+			if len(stems) > 0 {
+				phrases = append(phrases, stems)
+			}
+			continue
+		}
+		for _, stem := range stems {
+			phrases = append(phrases, []string{stem})
+		}
+	}
+	return phrases, nil
+}
+
+// Splits text into words and stems them
+func tokenize(text string) []indexedToken {
+	var tokens []indexedToken
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r) || r == '\''
+		if isWord && start < 0 {
+			start = i
+		}
+		if !isWord && start >= 0 {
+			tokens = appendToken(tokens, text, start, i)
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = appendToken(tokens, text, start, len(text))
+	}
+	return tokens
+}
+
+func appendToken(tokens []indexedToken, text string, start, end int) []indexedToken {
+	// possessives are indexed without the "'s" and stray apostrophes are dropped
+	word := strings.Trim(strings.TrimSuffix(strings.ToLower(text[start:end]), "'s"), "'")
+	if word == "" {
+		return tokens
+	}
+	return append(tokens, indexedToken{stem(word), start, end})
+}
+
+// A light suffix stripping stemmer, roughly the first step of the Porter
+// stemmer. It is not perfect, but it only has to map different forms of a
+// word to the same stem, not produce real words.
+func stem(word string) string {
+	if utf8.RuneCountInString(word) <= 3 {
+		return word
+	}
+	switch {
+	case strings.HasSuffix(word, "sses"):
+		word = strings.TrimSuffix(word, "es")
+	case strings.HasSuffix(word, "ies"):
+		word = strings.TrimSuffix(word, "es")
+	case strings.HasSuffix(word, "ss"), strings.HasSuffix(word, "us"):
+	case strings.HasSuffix(word, "s"):
+		word = strings.TrimSuffix(word, "s")
+	}
+
+	switch {
+	case strings.HasSuffix(word, "eed"):
+		word = strings.TrimSuffix(word, "d")
+	case strings.HasSuffix(word, "ed") && hasVowel(word[:len(word)-2]):
+		word = fixStem(word[:len(word)-2])
+	case strings.HasSuffix(word, "ing") && hasVowel(word[:len(word)-3]):
+		word = fixStem(word[:len(word)-3])
+	}
+
+	if strings.HasSuffix(word, "y") && hasVowel(word[:len(word)-1]) {
+		word = word[:len(word)-1] + "i"
+	}
+	return word
+}
+
+// Cleans up a stem whose "ed" or "ing" was just removed
+func fixStem(word string) string {
+	n := len(word)
+	switch {
+	case strings.HasSuffix(word, "at"), strings.HasSuffix(word, "bl"), strings.HasSuffix(word, "iz"):
+		return word + "e"
+	case n >= 2 && word[n-1] == word[n-2] && !strings.ContainsAny(word[n-1:], "aeioulsz"):
+		return word[:n-1]
+	}
+	return word
+}
+
+func hasVowel(word string) bool {
+	return strings.ContainsAny(word, "aeiouy")
+}
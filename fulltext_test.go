@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var FullTextSpells = Spells{
+	{Index: "cause-fear", Desc: "You awaken the sense of mortality in one creature. The target becomes frightened of you until the spell ends."},
+	{Index: "fear", Desc: "Each creature in a 30-foot cone must drop whatever it is holding and become frightened for the duration.\n\nWhile frightened by this spell, a creature must take the Dash action."},
+	{Index: "fireball", Desc: "A bright streak flashes from your pointing finger. Each creature takes 8d6 fire damage.", HigherLevel: "The damage increases by 1d6 for each slot level above 3rd."},
+	{Index: "heroism", Desc: "A willing creature you touch is imbued with bravery. It can't be frightening to itself."},
+}
+
+func TestStem(t *testing.T) {
+	var tests = []struct {
+		words []string
+		want  string
+	}{
+		{[]string{"frighten", "frightened", "frightening", "frightens"}, "frighten"},
+		{[]string{"creature", "creatures"}, "creature"},
+		{[]string{"stop", "stopped", "stopping"}, "stop"},
+		{[]string{"bless", "blesses"}, "bless"},
+		{[]string{"enemy", "enemies"}, "enemi"},
+		{[]string{"fly"}, "fly"},
+	}
+	for _, test := range tests {
+		for _, word := range test.words {
+			if output := stem(word); output != test.want {
+				t.Errorf("Unexpected stem of \"%s\", expected \"%s\", but got \"%s\"", word, test.want, output)
+			}
+		}
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	var tests = []struct {
+		query   string
+		want    []string
+		wantErr bool
+	}{
+		// fear mentions "frightened" twice, heroism is shorter than cause-fear
+		{"frightened", []string{"fear", "heroism", "cause-fear"}, false},
+		{"FRIGHTENING creature", []string{"fear", "heroism", "cause-fear"}, false},
+		{"\"becomes frightened\"", []string{"cause-fear", "fear"}, false},
+		{"\"target becomes frightened\"", []string{"cause-fear"}, false},
+		{"\"become frightened\" dash", []string{"fear"}, false},
+		{"\"frightened creature\"", nil, false},
+		{"slot level", []string{"fireball"}, false},
+		{"8d6", []string{"fireball"}, false},
+		{"meow", nil, false},
+		{"", nil, false},
+		{"\"frightened", nil, true},
+	}
+
+	idx := NewSearchIndex(FullTextSpells)
+	for _, test := range tests {
+		hits, err := idx.Search(test.query)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Unexpected error for \"%s\": %v", test.query, err)
+			continue
+		}
+		var output []string
+		for _, hit := range hits {
+			output = append(output, FullTextSpells[hit.Doc].Index)
+		}
+		if !reflect.DeepEqual(output, test.want) {
+			t.Errorf("Unexpected result for \"%s\".\nhave: \"%v\"\nwant: \"%v\"", test.query, output, test.want)
+		}
+	}
+}
+
+func TestSearchSnippet(t *testing.T) {
+	var tests = []struct {
+		query string
+		want  string
+	}{
+		{"target frightened", "The " + HlghtSubstr + "target" + HlghtNormal + " becomes " + HlghtSubstr + "frightened" + HlghtNormal + " of you until the spell ends."},
+		{"slot", "The damage increases by 1d6 for each " + HlghtSubstr + "slot" + HlghtNormal + " level above 3rd."},
+		{"\"sense of\"", "You awaken the " + HlghtSubstr + "sense" + HlghtNormal + " " + HlghtSubstr + "of" + HlghtNormal + " mortality in one creature."},
+	}
+
+	idx := NewSearchIndex(FullTextSpells)
+	for _, test := range tests {
+		hits, _ := idx.Search(test.query)
+		if len(hits) == 0 {
+			t.Errorf("No hits for \"%s\"", test.query)
+			continue
+		}
+		if hits[0].Snippet != test.want {
+			t.Errorf("Unexpected snippet for \"%s\".\nhave: \"%s\"\nwant: \"%s\"", test.query, hits[0].Snippet, test.want)
+		}
+	}
+}
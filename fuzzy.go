@@ -0,0 +1,149 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Scores of the match kinds. They are far apart so that a prefix match
+// always ranks above a substring match, which always ranks above a fuzzy one.
+const (
+	fuzzyPrefixScore    = 3000
+	fuzzySubstringScore = 2000
+	// bonuses and penalties of single runes in a fuzzy match
+	fuzzyMatchBonus       = 1
+	fuzzyWordStartBonus   = 10
+	fuzzyConsecutiveBonus = 8
+	fuzzyGapPenalty       = 1
+	fuzzyMaxGapPenalty    = 5
+)
+
+// Matches pattern against str regardless of capitalisation. The pattern
+// matches if all of its runes are found in str in the same order, but not
+// necessarily next to each other, so "mgmsl" matches "Magic Missile".
+// Returns the score of the match (higher is better) and byte offsets in str
+// of the matched runes. ok is false if there is no match.
+func fuzzyMatch(str, pattern string) (score int, positions []int, ok bool) {
+	if pattern == "" {
+		return 0, nil, true
+	}
+	lstr, lpattern := strings.ToLower(str), strings.ToLower(pattern)
+	if len(lstr) == len(str) {
+		if strings.HasPrefix(lstr, lpattern) {
+			return fuzzyPrefixScore, bytePositions(0, len(lpattern)), true
+		}
+		if i := strings.Index(lstr, lpattern); i >= 0 {
+			score = fuzzySubstringScore
+			if isWordStart(str, i) {
+				score += fuzzyWordStartBonus
+			}
+			return score, bytePositions(i, len(lpattern)), true
+		}
+	}
+	return fuzzySubsequence(str, pattern)
+}
+
+// Finds the best scoring way to match runes of the pattern in str in order.
+// best[i][j] is the best score of the first i+1 pattern runes where the last
+// one is matched at rune j of str.
+func fuzzySubsequence(str, pattern string) (int, []int, bool) {
+	srunes, offsets := lowerRunes(str)
+	prunes, _ := lowerRunes(pattern)
+	n, m := len(srunes), len(prunes)
+	if m > n {
+		return 0, nil, false
+	}
+
+	const none = -1 << 30
+	best := make([][]int, m)
+	from := make([][]int, m)
+	for i := range best {
+		best[i] = make([]int, n)
+		from[i] = make([]int, n)
+		for j := range best[i] {
+			best[i][j] = none
+		}
+	}
+
+	for i := 0; i < m; i++ {
+		for j := i; j < n; j++ {
+			if srunes[j] != prunes[i] {
+				continue
+			}
+			bonus := fuzzyMatchBonus
+			if isWordStart(str, offsets[j]) {
+				bonus += fuzzyWordStartBonus
+			}
+			if i == 0 {
+				best[i][j] = bonus
+				continue
+			}
+			for k := i - 1; k < j; k++ {
+				if best[i-1][k] == none {
+					continue
+				}
+				score := best[i-1][k] + bonus
+				if k == j-1 {
+					score += fuzzyConsecutiveBonus
+				} else {
+					gap := (j - k - 1) * fuzzyGapPenalty
+					if gap > fuzzyMaxGapPenalty {
+						gap = fuzzyMaxGapPenalty
+					}
+					score -= gap
+				}
+				if score > best[i][j] {
+					best[i][j] = score
+					from[i][j] = k
+				}
+			}
+		}
+	}
+
+	end := -1
+	for j := 0; j < n; j++ {
+		if best[m-1][j] != none && (end < 0 || best[m-1][j] > best[m-1][end]) {
+			end = j
+		}
+	}
+	if end < 0 {
+		return 0, nil, false
+	}
+
+	positions := make([]int, m)
+	for i, j := m-1, end; i >= 0; i-- {
+		positions[i] = offsets[j]
+		j = from[i][j]
+	}
+	return best[m-1][end], positions, true
+}
+
+// Returns lowercased runes of a string and byte offsets at which they start
+func lowerRunes(str string) ([]rune, []int) {
+	var runes []rune
+	var offsets []int
+	for i, r := range str {
+		runes = append(runes, unicode.ToLower(r))
+		offsets = append(offsets, i)
+	}
+	return runes, offsets
+}
+
+// Reports whether the rune at byte offset i starts a word
+func isWordStart(str string, i int) bool {
+	if i == 0 {
+		return true
+	}
+	prev, _ := utf8.DecodeLastRuneInString(str[:i])
+	return !unicode.IsLetter(prev) && !unicode.IsDigit(prev)
+}
+
+// Returns byte offsets from start up to, but not including start+length
+func bytePositions(start, length int) []int {
+	positions := make([]int, length)
+	for i := range positions {
+		positions[i] = start + i
+	}
+	return positions
+}
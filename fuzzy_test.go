@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	var tests = []struct {
+		str       string
+		pattern   string
+		ok        bool
+		positions []int
+	}{
+		{"Magic Missile", "mgmsl", true, []int{0, 2, 6, 8, 11}},
+		{"Magic Missile", "magic", true, []int{0, 1, 2, 3, 4}},
+		{"Magic Missile", "MISS", true, []int{6, 7, 8, 9}},
+		{"Magic Missile", "mm", true, []int{0, 6}},
+		{"Magic Missile", "missm", false, nil},
+		{"Magic Missile", "", true, nil},
+		{"Ice Knife", "iknf", true, []int{0, 4, 5, 7}},
+	}
+	for _, test := range tests {
+		_, positions, ok := fuzzyMatch(test.str, test.pattern)
+		if ok != test.ok || !reflect.DeepEqual(positions, test.positions) {
+			t.Errorf("Unexpected result for \"%s\" in \"%s\", expected %v %v, but got %v %v", test.pattern, test.str, test.ok, test.positions, ok, positions)
+		}
+	}
+}
+
+func TestFuzzyMatchRanking(t *testing.T) {
+	// each string must score higher than the ones after it
+	var tests = []struct {
+		pattern string
+		strs    []string
+	}{
+		{"fire", []string{"Fireball", "Wall of Fire", "Wildfire", "Flaming Sphere"}},
+		{"mm", []string{"Mmm", "Summon", "Magic Missile", "Demiplane Mask"}},
+		{"cw", []string{"Cure Wounds", "Chill Touch of Woe"}},
+	}
+	for _, test := range tests {
+		prev := 1 << 30
+		for _, str := range test.strs {
+			score, _, ok := fuzzyMatch(str, test.pattern)
+			if !ok {
+				t.Errorf("Expected \"%s\" to match \"%s\"", test.pattern, str)
+				continue
+			}
+			if score >= prev {
+				t.Errorf("Expected \"%s\" to score lower than the previous string for \"%s\"", str, test.pattern)
+			}
+			prev = score
+		}
+	}
+}
@@ -3,11 +3,15 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"sync"
 )
 
-// Logger logs
+// Logger logs. It can be used from multiple goroutines, per example by
+// fetchers of all sources at once
 type Logger struct {
+	mu     sync.Mutex
 	file   *os.File
 	writer *bufio.Writer
 }
@@ -27,7 +31,15 @@ func NewLogger(filepath string) *Logger {
 	return &logger
 }
 
+// NewWriterLogger returns a Logger which writes to w instead of a file, per
+// example to stderr or ioutil.Discard
+func NewWriterLogger(w io.Writer) *Logger {
+	return &Logger{writer: bufio.NewWriter(w)}
+}
+
 func (l *Logger) Clear(text string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	l.writer.Flush()
 }
 
@@ -36,13 +48,19 @@ func (l *Logger) formatStr(evt EventType, text string) string {
 }
 
 func (l *Logger) Log(evt EventType, text string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	l.writer.WriteString(l.formatStr(evt, text))
 }
 
 func (l *Logger) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	// flush the buffer aka write anything left
 	// in the buffer into the file
 	l.writer.Flush()
 	// close the file. Error checks are not performed.
-	l.file.Close()
+	if l.file != nil {
+		l.file.Close()
+	}
 }
@@ -1,7 +1,14 @@
 package main
 
+import "os"
+
 func main() {
-	app := newApp()
+	// arguments select a subcommand which runs without the terminal UI
+	if len(os.Args) > 1 {
+		runCLIMain(os.Args[1:])
+	}
+
+	app := newApp(defaultEnv())
 
 	app.Run()
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// FetchProgress is how far fetching of a source from an API has come
+type FetchProgress struct {
+	// name of the source which is fetched
+	Source string
+	// fetched and all pages of the API
+	Page, Pages int
+	// fetched and all spells of the API
+	Spells, Count int
+}
+
+func (p FetchProgress) String() string {
+	return fmt.Sprintf("page %d/%d, %d/%d spells", p.Page, p.Pages, p.Spells, p.Count)
+}
+
+// Gauge renders the progress as a bar of the given width followed by the
+// source and the counts
+func (p FetchProgress) Gauge(width int) string {
+	filled := width
+	if p.Count > 0 && p.Spells < p.Count {
+		filled = width * p.Spells / p.Count
+	}
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+	return fmt.Sprintf("%s %s %s", p.Source, bar, p)
+}
+
+// Counts fetched pages which can arrive from multiple goroutines and reports
+// the progress after each one
+type progressCounter struct {
+	mu       sync.Mutex
+	progress FetchProgress
+	// can be nil if the progress isn't reported
+	report func(FetchProgress)
+}
+
+func newProgressCounter(count, pageSize int, report func(FetchProgress)) *progressCounter {
+	pages := 1
+	if pageSize > 0 && count > pageSize {
+		pages = (count + pageSize - 1) / pageSize
+	}
+	return &progressCounter{progress: FetchProgress{Pages: pages, Count: count}, report: report}
+}
+
+// Adds a fetched page with the number of spells on it
+func (c *progressCounter) add(spells int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.progress.Page++
+	c.progress.Spells += spells
+	// the count could have changed since the first page was fetched
+	if c.progress.Page > c.progress.Pages {
+		c.progress.Pages = c.progress.Page
+	}
+	if c.progress.Spells > c.progress.Count {
+		c.progress.Count = c.progress.Spells
+	}
+	if c.report != nil {
+		c.report(c.progress)
+	}
+}
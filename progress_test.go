@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestFetchProgressGauge(t *testing.T) {
+	var tests = []struct {
+		progress FetchProgress
+		want     string
+	}{
+		{FetchProgress{"remote spells", 3, 7, 150, 321}, "remote spells ████░░░░░░ page 3/7, 150/321 spells"},
+		{FetchProgress{"remote spells", 7, 7, 321, 321}, "remote spells ██████████ page 7/7, 321/321 spells"},
+		{FetchProgress{"mirror", 1, 1, 0, 0}, "mirror ██████████ page 1/1, 0/0 spells"},
+	}
+	for _, test := range tests {
+		if output := test.progress.Gauge(10); output != test.want {
+			t.Errorf("Unexpected result, expected \"%s\", but got \"%s\"", test.want, output)
+		}
+	}
+}
+
+func TestFetchSpellsProgress(t *testing.T) {
+	pages := NewPageFetcher(NewAPIClient())
+
+	for _, paged := range []bool{false, true} {
+		server := newSpellServer(321, 50, paged, 0)
+		var mu sync.Mutex
+		var reports []FetchProgress
+		var output Spells
+		_, err := fetchSpells(context.Background(), pages, server.URL+"/spells/", &output, func(p FetchProgress) {
+			mu.Lock()
+			reports = append(reports, p)
+			mu.Unlock()
+		})
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(reports) != 7 {
+			t.Fatalf("Expected 7 reports, but got %d", len(reports))
+		}
+		for i, p := range reports {
+			if p.Page != i+1 || p.Pages != 7 || p.Count != 321 {
+				t.Errorf("Unexpected report %d: %v", i, p)
+			}
+			if i > 0 && p.Spells <= reports[i-1].Spells {
+				t.Errorf("Expected the spell count to grow, but got %v after %v", p, reports[i-1])
+			}
+		}
+		if last := reports[len(reports)-1]; last.Spells != 321 {
+			t.Errorf("Expected all spells to be fetched, but got %v", last)
+		}
+	}
+}
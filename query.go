@@ -0,0 +1,443 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Query is a parsed spell filter typed into the input field. It consists of
+// free text terms which are matched against spell names and field terms like
+// "level:1-3" or "class:wizard". Terms can be combined with AND, OR, NOT and
+// parentheses. Terms next to each other are implicitly ANDed, so
+// `level:2 class:bard conc:yes` finds all 2nd level bard concentration spells.
+type Query struct {
+	root queryNode
+	// free text terms that are not negated. They are used for highlighting
+	// the matched part of spell names.
+	Terms []string
+	// values of name: terms that are not negated. They are highlighted too,
+	// but only where they are contained in the name.
+	names []string
+}
+
+// ParseQuery parses the query typed into the input field. An empty query
+// matches all spells.
+func ParseQuery(input string) (*Query, error) {
+	tokens, err := lexQuery(input)
+	if err != nil {
+		return nil, err
+	}
+	p := queryParser{tokens: tokens}
+	q := Query{}
+	if len(tokens) == 0 {
+		return &q, nil
+	}
+	if q.root, err = p.parseOr(); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("Unexpected %s", p.tokens[p.pos])
+	}
+	q.Terms = collectTerms(q.root, false, textTerm)
+	q.names = collectTerms(q.root, false, nameTerm)
+	return &q, nil
+}
+
+// Match reports whether the spell satisfies the query. A nil query matches
+// every spell.
+func (q *Query) Match(s *Spell) bool {
+	if q == nil || q.root == nil {
+		return true
+	}
+	return q.root.match(s)
+}
+
+// Score returns how well the spell name matches the free text terms of the
+// query. Higher is better. It is nil safe.
+func (q *Query) Score(s *Spell) int {
+	if q == nil {
+		return 0
+	}
+	var total int
+	for _, term := range q.Terms {
+		if score, _, ok := fuzzyMatch(s.Name, term); ok {
+			total += score
+		}
+	}
+	return total
+}
+
+// HighlightMask returns which bytes of the spell name were matched by the
+// free text and name: terms of the query. Terms that are contained in the
+// name mark all their occurences, while fuzzy matched ones mark only the
+// matched runes. It is nil safe.
+func (q *Query) HighlightMask(name string) []bool {
+	mask := make([]bool, len(name))
+	if q == nil {
+		return mask
+	}
+	for _, term := range q.names {
+		markTerm(name, term, mask)
+	}
+	for _, term := range q.Terms {
+		if strings.Contains(strings.ToLower(name), term) {
+			markTerm(name, term, mask)
+			continue
+		}
+		_, positions, ok := fuzzyMatch(name, term)
+		if !ok {
+			continue
+		}
+		for _, p := range positions {
+			_, size := utf8.DecodeRuneInString(name[p:])
+			for i := p; i < p+size; i++ {
+				mask[i] = true
+			}
+		}
+	}
+	return mask
+}
+
+type queryNode interface {
+	match(s *Spell) bool
+}
+
+type andNode struct{ left, right queryNode }
+type orNode struct{ left, right queryNode }
+type notNode struct{ node queryNode }
+
+// free text, fuzzy matched against the spell name
+type textNode struct{ text string }
+
+// a field term, per example "school:evocation"
+type fieldNode struct {
+	field string
+	value string
+	pred  func(s *Spell) bool
+}
+
+func (n andNode) match(s *Spell) bool { return n.left.match(s) && n.right.match(s) }
+func (n orNode) match(s *Spell) bool  { return n.left.match(s) || n.right.match(s) }
+func (n notNode) match(s *Spell) bool { return !n.node.match(s) }
+func (n textNode) match(s *Spell) bool {
+	_, _, ok := fuzzyMatch(s.Name, n.text)
+	return ok
+}
+func (n fieldNode) match(s *Spell) bool { return n.pred(s) }
+
+// Returns the terms in the tree for whose leaves term returns true. Terms
+// under an odd number of NOTs are skipped since they can never be a part of
+// a matched name.
+func collectTerms(n queryNode, negated bool, term func(queryNode) (string, bool)) []string {
+	switch n := n.(type) {
+	case andNode:
+		return append(collectTerms(n.left, negated, term), collectTerms(n.right, negated, term)...)
+	case orNode:
+		return append(collectTerms(n.left, negated, term), collectTerms(n.right, negated, term)...)
+	case notNode:
+		return collectTerms(n.node, !negated, term)
+	}
+	if t, ok := term(n); ok && !negated {
+		return []string{t}
+	}
+	return nil
+}
+
+// Returns the text of a free text term
+func textTerm(n queryNode) (string, bool) {
+	t, ok := n.(textNode)
+	return t.text, ok
+}
+
+// Returns the value of a name: term
+func nameTerm(n queryNode) (string, bool) {
+	f, ok := n.(fieldNode)
+	return f.value, ok && f.field == "name"
+}
+
+type queryTokenKind int
+
+const (
+	tokWord queryTokenKind = iota
+	tokQuoted
+	tokLParen
+	tokRParen
+)
+
+type queryToken struct {
+	kind queryTokenKind
+	text string
+}
+
+func (t queryToken) String() string {
+	if t.kind == tokQuoted {
+		return strconv.Quote(t.text)
+	}
+	return "\"" + t.text + "\""
+}
+
+// Splits the query into tokens. A word can contain a quoted part so that
+// field values can have spaces in them, per example class:"eldritch knight".
+func lexQuery(input string) ([]queryToken, error) {
+	var tokens []queryToken
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, queryToken{tokLParen, "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, queryToken{tokRParen, ")"})
+			i++
+		case r == '"':
+			end := indexRune(runes, '"', i+1)
+			if end < 0 {
+				return nil, fmt.Errorf("Unclosed quote")
+			}
+			tokens = append(tokens, queryToken{tokQuoted, string(runes[i+1 : end])})
+			i = end + 1
+		default:
+			var word []rune
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' {
+				if runes[i] == '"' {
+					end := indexRune(runes, '"', i+1)
+					if end < 0 {
+						return nil, fmt.Errorf("Unclosed quote")
+					}
+					word = append(word, runes[i+1:end]...)
+					i = end + 1
+					continue
+				}
+				word = append(word, runes[i])
+				i++
+			}
+			tokens = append(tokens, queryToken{tokWord, string(word)})
+		}
+	}
+	return tokens, nil
+}
+
+// Returns the index of the first r in runes at or after from, -1 if there is none
+func indexRune(runes []rune, r rune, from int) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// Recursive descent parser of the query. Precedence from the lowest is OR,
+// AND, NOT.
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+// Returns whether the next token is the given operator. Operators are case
+// insensitive.
+func (p *queryParser) peekOp(op string) bool {
+	if p.pos >= len(p.tokens) {
+		return false
+	}
+	t := p.tokens[p.pos]
+	return t.kind == tokWord && strings.EqualFold(t.text, op)
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOp("OR") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.pos < len(p.tokens) && p.tokens[p.pos].kind != tokRParen && !p.peekOp("OR") {
+		// AND is optional, terms next to each other are ANDed anyway
+		if p.peekOp("AND") {
+			p.pos++
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseNot() (queryNode, error) {
+	if p.peekOp("NOT") {
+		p.pos++
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("Unexpected end of query")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	switch t.kind {
+	case tokLParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokRParen {
+			return nil, fmt.Errorf("Missing closing parenthesis")
+		}
+		p.pos++
+		return node, nil
+	case tokRParen:
+		return nil, fmt.Errorf("Unexpected %s", t)
+	case tokQuoted:
+		return textNode{strings.ToLower(t.text)}, nil
+	}
+	if i := strings.Index(t.text, ":"); i > 0 {
+		return newFieldNode(strings.ToLower(t.text[:i]), t.text[i+1:])
+	}
+	if p.isOperator(t) {
+		return nil, fmt.Errorf("Unexpected %s", t)
+	}
+	return textNode{strings.ToLower(t.text)}, nil
+}
+
+func (p *queryParser) isOperator(t queryToken) bool {
+	for _, op := range []string{"AND", "OR", "NOT"} {
+		if strings.EqualFold(t.text, op) {
+			return true
+		}
+	}
+	return false
+}
+
+// Functions that make a predicate out of a field value, for each supported
+// field of a query
+var queryFields = map[string]func(value string) (func(s *Spell) bool, error){
+	"name": containsField(func(s *Spell) string { return s.Name }),
+	"level": func(value string) (func(s *Spell) bool, error) {
+		min, max, err := parseLevelRange(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *Spell) bool { return s.Level >= min && s.Level <= max }, nil
+	},
+	"class": func(value string) (func(s *Spell) bool, error) {
+		value = strings.ToLower(value)
+		return func(s *Spell) bool { return s.HasClass(value) }, nil
+	},
+	"school":   containsField(func(s *Spell) string { return s.School.Name }),
+	"time":     containsField(func(s *Spell) string { return s.CastingTime }),
+	"range":    containsField(func(s *Spell) string { return s.Range }),
+	"duration": containsField(func(s *Spell) string { return s.Duration }),
+	"comp": func(value string) (func(s *Spell) bool, error) {
+		return func(s *Spell) bool {
+			for _, c := range s.Components {
+				if strings.EqualFold(c, value) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	},
+	"ritual": boolField(func(s *Spell) bool { return s.Ritual }),
+	"conc":   boolField(func(s *Spell) bool { return s.Concentration }),
+	"page":   containsField(func(s *Spell) string { return s.Page }),
+	"doc":    containsField(func(s *Spell) string { return s.Document.Slug + " " + s.Document.Title }),
+	"list": func(value string) (func(s *Spell) bool, error) {
+		return func(s *Spell) bool {
+			for _, l := range s.SpellLists {
+				if strings.EqualFold(l, value) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	},
+}
+
+func newFieldNode(field, value string) (queryNode, error) {
+	newPred, ok := queryFields[field]
+	if !ok {
+		return nil, fmt.Errorf("Unknown field: %s", field)
+	}
+	if value == "" {
+		return nil, fmt.Errorf("Missing value for %s", field)
+	}
+	pred, err := newPred(value)
+	if err != nil {
+		return nil, err
+	}
+	return fieldNode{field, value, pred}, nil
+}
+
+// Returns a predicate constructor which matches if the value is contained
+// in the string returned by get, regardless of capitalisation
+func containsField(get func(s *Spell) string) func(string) (func(s *Spell) bool, error) {
+	return func(value string) (func(s *Spell) bool, error) {
+		value = strings.ToLower(value)
+		return func(s *Spell) bool {
+			return strings.Contains(strings.ToLower(get(s)), value)
+		}, nil
+	}
+}
+
+// Returns a predicate constructor which accepts yes/no values
+func boolField(get func(s *Spell) bool) func(string) (func(s *Spell) bool, error) {
+	return func(value string) (func(s *Spell) bool, error) {
+		var want bool
+		switch strings.ToLower(value) {
+		case "yes", "y", "true":
+			want = true
+		case "no", "n", "false":
+			want = false
+		default:
+			return nil, fmt.Errorf("Expected yes or no, got: %s", value)
+		}
+		return func(s *Spell) bool { return get(s) == want }, nil
+	}
+}
+
+// Parses a level like "3" or a range like "1-3"
+func parseLevelRange(value string) (min, max int, err error) {
+	parts := strings.SplitN(value, "-", 2)
+	if min, err = strconv.Atoi(parts[0]); err != nil {
+		return 0, 0, fmt.Errorf("Invalid level: %s", value)
+	}
+	max = min
+	if len(parts) == 2 {
+		if max, err = strconv.Atoi(parts[1]); err != nil {
+			return 0, 0, fmt.Errorf("Invalid level: %s", value)
+		}
+	}
+	if min > max {
+		return 0, 0, fmt.Errorf("Invalid level range: %s", value)
+	}
+	return min, max, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	var tests = []struct {
+		query   string
+		want    []string
+		terms   []string
+		wantErr bool
+	}{
+		// acid-arrow, acid-splash, cone-of-cold, confusion
+		{"", []string{"acid-arrow", "acid-splash", "cone-of-cold", "confusion"}, nil, false},
+		{"acid", []string{"acid-arrow", "acid-splash"}, []string{"acid"}, false},
+		{"\"acid ar\"", []string{"acid-arrow"}, []string{"acid ar"}, false},
+		{"level:2", []string{"acid-arrow"}, nil, false},
+		{"level:1-4", []string{"acid-arrow", "confusion"}, nil, false},
+		{"class:wizard school:evo", []string{"acid-arrow", "cone-of-cold"}, nil, false},
+		{"class:\"cleric (knowledge)\"", []string{"confusion"}, nil, false},
+		{"conc:yes", []string{"confusion"}, nil, false},
+		{"conc:no ritual:no time:action", []string{"acid-arrow", "acid-splash", "cone-of-cold"}, nil, false},
+		{"acid AND NOT splash", []string{"acid-arrow"}, []string{"acid"}, false},
+		{"cold or confusion", []string{"cone-of-cold", "confusion"}, []string{"cold", "confusion"}, false},
+		{"(level:0 OR level:5) class:sorcerer", []string{"acid-splash", "cone-of-cold"}, nil, false},
+		{"not (acid or cold)", []string{"confusion"}, nil, false},
+		{"comp:m", []string{"acid-arrow", "cone-of-cold"}, nil, false},
+		{"page:phb", []string{"acid-arrow", "acid-splash", "confusion"}, nil, false},
+		{"list:bard", []string{"confusion"}, nil, false},
+		{"doc:srd", []string{"acid-arrow", "cone-of-cold", "confusion"}, nil, false},
+		{"doc:\"reference document\"", []string{"acid-splash"}, nil, false},
+		{"level:", nil, nil, true},
+		{"level:3-1", nil, nil, true},
+		{"conc:maybe", nil, nil, true},
+		{"meow:yes", nil, nil, true},
+		{"(acid", nil, nil, true},
+		{"acid)", nil, nil, true},
+		{"\"acid", nil, nil, true},
+		{"acid or", nil, nil, true},
+	}
+
+	for _, test := range tests {
+		q, err := ParseQuery(test.query)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Unexpected error for \"%s\": %v", test.query, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var output []string
+		for i := range ExampleSpells {
+			if q.Match(&ExampleSpells[i]) {
+				output = append(output, ExampleSpells[i].Index)
+			}
+		}
+		if !reflect.DeepEqual(output, test.want) {
+			t.Errorf("Unexpected result for \"%s\".\nhave: \"%v\"\nwant: \"%v\"", test.query, output, test.want)
+		}
+		if !reflect.DeepEqual(q.Terms, test.terms) {
+			t.Errorf("Unexpected terms for \"%s\".\nhave: \"%v\"\nwant: \"%v\"", test.query, q.Terms, test.terms)
+		}
+	}
+}
+
+func TestHighlightMask(t *testing.T) {
+	on := func(s string) string { return HlghtSubstr + s + HlghtNormal }
+	var tests = []struct {
+		str   string
+		query string
+		want  string
+	}{
+		{"Aura of Light", "Light", "Aura of " + on("Light")},
+		{"Light of Aura", "\"Light \"", on("Light ") + "of Aura"},
+		{"Aura of Aura", "aura", on("Aura") + " of " + on("Aura")},
+		{"Seagull is a boring animal", "BoRiNg", "Seagull is a " + on("boring") + " animal"},
+		{"Dog Meo Dog", "Meow", "Dog Meo Dog"},
+		{"Cone of Cold", "cone cold", on("Cone") + " of " + on("Cold")},
+		{"Cone of Cold", "\"one o\" f", "C" + on("one of") + " Cold"},
+		{"Cone of Cold", "cn", on("C") + "o" + on("n") + "e of Cold"},
+		{"Cone of Cold", "", "Cone of Cold"},
+		// name: terms are highlighted where they are contained in the name
+		{"Cone of Cold", "name:cold", "Cone of " + on("Cold")},
+		{"Cone of Cold", "name:\"of c\" level:5", "Cone " + on("of C") + "old"},
+		{"Cone of Cold", "NOT name:cold", "Cone of Cold"},
+		{"Cone of Cold", "level:5", "Cone of Cold"},
+	}
+	for _, test := range tests {
+		q, err := ParseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := highlightMask(test.str, q.HighlightMask(test.str))
+		if output != test.want {
+			t.Errorf("Unexpected result for \"%s\", expected \"%s\", but got \"%s\"", test.query, test.want, output)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// SlotTracker keeps track of spell slots of a single character. Regular slots
+// are indexed by level-1. Warlock pact slots are tracked separately since all
+// of them are of the same level and they are regained on a short rest.
+type SlotTracker struct {
+	Character string `json:"character"`
+	Max       [9]int `json:"max"`
+	Used      [9]int `json:"used"`
+	PactMax   int    `json:"pact_max"`
+	PactUsed  int    `json:"pact_used"`
+	PactLevel int    `json:"pact_level"`
+}
+
+// LoadSlotTracker loads slots of a character from the directory. If the
+// character has no slots saved yet, a tracker without any slots is returned.
+func LoadSlotTracker(dir, character string) (*SlotTracker, error) {
+	t := SlotTracker{Character: character}
+	file := path.Join(dir, safeFileName(character)+".json")
+	if !checkFile(file) {
+		return &t, nil
+	}
+	if err := loadJSONFromFile(file, &t); err != nil {
+		return nil, err
+	}
+	t.clamp()
+	return &t, nil
+}
+
+// Brings the numbers of slots of a hand edited or stale file into range, so
+// that used slots are never more than there are
+func (t *SlotTracker) clamp() {
+	for i := range t.Max {
+		clampSlots(&t.Max[i], &t.Used[i])
+	}
+	clampSlots(&t.PactMax, &t.PactUsed)
+}
+
+func clampSlots(max, used *int) {
+	if *max < 0 {
+		*max = 0
+	}
+	if *used < 0 {
+		*used = 0
+	}
+	if *used > *max {
+		*used = *max
+	}
+}
+
+// Save writes the tracker to the directory
+func (t *SlotTracker) Save(dir string) error {
+	if err := readyDir(dir); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(t, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(dir, safeFileName(t.Character)+".json"), data, 0644)
+}
+
+// SetMax sets the number of slots of a level
+func (t *SlotTracker) SetMax(level, n int) error {
+	if level < 1 || level > 9 {
+		return fmt.Errorf("Invalid slot level: %d", level)
+	}
+	if n < 0 {
+		return fmt.Errorf("Invalid number of slots: %d", n)
+	}
+	t.Max[level-1] = n
+	if t.Used[level-1] > n {
+		t.Used[level-1] = n
+	}
+	return nil
+}
+
+// SetPact sets the number and the level of pact slots
+func (t *SlotTracker) SetPact(n, level int) error {
+	if level < 1 || level > 5 {
+		return fmt.Errorf("Invalid pact slot level: %d", level)
+	}
+	if n < 0 {
+		return fmt.Errorf("Invalid number of pact slots: %d", n)
+	}
+	t.PactMax, t.PactLevel = n, level
+	if t.PactUsed > n {
+		t.PactUsed = n
+	}
+	return nil
+}
+
+// Returns whether a regular slot of the level is left. There are no slots of
+// levels outside 1-9.
+func (t *SlotTracker) hasSlot(level int) bool {
+	if level < 1 || level > 9 {
+		return false
+	}
+	return t.Used[level-1] < t.Max[level-1]
+}
+
+// Returns whether a pact slot good enough for a spell of the level is left
+func (t *SlotTracker) hasPactSlot(level int) bool {
+	return t.PactUsed < t.PactMax && t.PactLevel >= level
+}
+
+// CanCast reports whether a spell of the level can be cast with the slots
+// that are left. Cantrips can always be cast.
+func (t *SlotTracker) CanCast(level int) bool {
+	if level == 0 {
+		return true
+	}
+	for l := level; l <= 9; l++ {
+		if t.hasSlot(l) {
+			return true
+		}
+	}
+	return t.hasPactSlot(level)
+}
+
+// Cast spends a slot for a spell of the level. If atLevel is 0, a slot of the
+// spell's level is spent, if there is none, a pact slot is, and if there
+// is none of those either, the lowest higher level slot is. Otherwise a slot
+// of exactly atLevel is spent, which is how spells are upcast. Returns the
+// level of the spent slot and whether it was a pact slot.
+func (t *SlotTracker) Cast(level, atLevel int) (slot int, pact bool, err error) {
+	if level < 0 || level > 9 {
+		return 0, false, fmt.Errorf("Invalid spell level: %d", level)
+	}
+	if level == 0 {
+		return 0, false, nil
+	}
+	if atLevel != 0 {
+		if atLevel < level || atLevel > 9 {
+			return 0, false, fmt.Errorf("Cannot cast a level %d spell with a level %d slot", level, atLevel)
+		}
+		if t.hasSlot(atLevel) {
+			t.Used[atLevel-1]++
+			return atLevel, false, nil
+		}
+		if t.PactLevel == atLevel && t.hasPactSlot(level) {
+			t.PactUsed++
+			return atLevel, true, nil
+		}
+		return 0, false, fmt.Errorf("No level %d slots left", atLevel)
+	}
+
+	if t.hasSlot(level) {
+		t.Used[level-1]++
+		return level, false, nil
+	}
+	if t.hasPactSlot(level) {
+		t.PactUsed++
+		return t.PactLevel, true, nil
+	}
+	for l := level + 1; l <= 9; l++ {
+		if t.hasSlot(l) {
+			t.Used[l-1]++
+			return l, false, nil
+		}
+	}
+	return 0, false, fmt.Errorf("No slots left for a level %d spell", level)
+}
+
+// ShortRest regains pact slots
+func (t *SlotTracker) ShortRest() {
+	t.PactUsed = 0
+}
+
+// LongRest regains all slots
+func (t *SlotTracker) LongRest() {
+	t.Used = [9]int{}
+	t.PactUsed = 0
+}
+
+// String renders the tracker for the slot panel. Available slots are shown
+// as filled circles and used ones as empty circles.
+func (t *SlotTracker) String() string {
+	text := "[::b]" + t.Character + "[::-]\n"
+	render := func(max, used int) string {
+		return "[#00ff00]" + strings.Repeat("●", max-used) + "[white]" + strings.Repeat("○", used)
+	}
+	for i, max := range t.Max {
+		if max > 0 {
+			text += fmt.Sprintf("[orange]%d[white] %s\n", i+1, render(max, t.Used[i]))
+		}
+	}
+	if t.PactMax > 0 {
+		text += fmt.Sprintf("[orange]Pact %d[white] %s\n", t.PactLevel, render(t.PactMax, t.PactUsed))
+	}
+	return text
+}
+
+// Returns a colored text telling whether the spell can be cast, "" if there
+// is no tracker
+func (t *SlotTracker) castStatus(s *Spell) string {
+	switch {
+	case t == nil || s == nil || s.Level < 0:
+		return ""
+	case t.CanCast(s.Level):
+		return "[#00ff00]Castable"
+	case s.Ritual:
+		return "[yellow]Castable as ritual"
+	}
+	return "[red]No slots left"
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSlotTrackerCast(t *testing.T) {
+	// a sorcerer 3 / warlock 2 multiclass
+	tracker := SlotTracker{Character: "Tester"}
+	tracker.SetMax(1, 2)
+	tracker.SetMax(2, 1)
+	tracker.SetPact(2, 1)
+
+	var tests = []struct {
+		level   int
+		atLevel int
+		slot    int
+		pact    bool
+		wantErr bool
+	}{
+		{0, 0, 0, false, false},
+		{1, 0, 1, false, false},
+		{1, 2, 2, false, false},
+		{1, 0, 1, false, false},
+		// regular level 1 slots are gone, pact slots come next
+		{1, 0, 1, true, false},
+		{1, 3, 0, false, true},
+		{2, 1, 0, false, true},
+		{1, 1, 1, true, false},
+		{1, 0, 0, false, true},
+		// levels which spells can't have
+		{10, 0, 0, false, true},
+		{-1, 0, 0, false, true},
+		{1, 10, 0, false, true},
+	}
+	for i, test := range tests {
+		slot, pact, err := tracker.Cast(test.level, test.atLevel)
+		if (err != nil) != test.wantErr || slot != test.slot || pact != test.pact {
+			t.Errorf("Unexpected result of cast %d, expected: %d %v %v, but got %d %v %v", i, test.slot, test.pact, test.wantErr, slot, pact, err)
+		}
+	}
+
+	if tracker.CanCast(1) || !tracker.CanCast(0) {
+		t.Errorf("Unexpected result of CanCast with no slots left")
+	}
+	tracker.ShortRest()
+	if !tracker.CanCast(1) || tracker.CanCast(2) {
+		t.Errorf("Unexpected result of CanCast after a short rest")
+	}
+	tracker.LongRest()
+	if !tracker.CanCast(2) || tracker.CanCast(3) {
+		t.Errorf("Unexpected result of CanCast after a long rest")
+	}
+}
+
+func TestSlotTrackerPersistence(t *testing.T) {
+	dir := t.TempDir()
+	tracker, err := LoadSlotTracker(dir, "Elminster")
+	if err != nil {
+		t.Fatalf("Got error: \"%s\" while loading a new character", err)
+	}
+	tracker.SetMax(9, 1)
+	tracker.Cast(9, 0)
+	if err := tracker.Save(dir); err != nil {
+		t.Fatalf("Got error: \"%s\" while saving", err)
+	}
+	loaded, err := LoadSlotTracker(dir, "Elminster")
+	if err != nil {
+		t.Fatalf("Got error: \"%s\" while loading", err)
+	}
+	if !reflect.DeepEqual(loaded, tracker) {
+		t.Errorf("Loaded tracker differs.\nhave: \"%#v\"\nwant: \"%#v\"", loaded, tracker)
+	}
+}
+
+func TestSlotTrackerLoadClamps(t *testing.T) {
+	dir := t.TempDir()
+	data := `{"character": "Elminster", "max": [2, 1, 0, 0, 0, 0, 0, 0, 0], "used": [5, -1, 1, 0, 0, 0, 0, 0, 0], "pact_max": 1, "pact_used": 3, "pact_level": 2}`
+	if err := ioutil.WriteFile(dir+"/elminster.json", []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tracker, err := LoadSlotTracker(dir, "Elminster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [9]int{2, 0, 0}; tracker.Used != want || tracker.PactUsed != 1 {
+		t.Errorf("Expected used slots to be clamped, but got %v and %d pact slots", tracker.Used, tracker.PactUsed)
+	}
+	// rendering used to panic with more used slots than there are
+	if text := tracker.String(); !strings.Contains(text, "○○") {
+		t.Errorf("Unexpected slots: %q", text)
+	}
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	neturl "net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Spells []Spell
@@ -33,6 +37,51 @@ type Spell struct {
 	School        struct{ Name string }
 	Classes       []struct{ Name string }
 	Subclasses    []struct{ Name string }
+	// the document the spell is published in, empty for custom spells
+	Document Document
+	// page of the document, per example "phb 259"
+	Page string
+	// level as the document names it, per example "2nd-level" or "Cantrip"
+	LevelName string
+	// slugs of the class spell lists the spell is on
+	SpellLists []string
+	// range in feet by which spells can be sorted, 0 for self and touch
+	RangeSort int
+	// flags of the API which duplicate Components, Ritual and Concentration.
+	// Those are filled in from the flags if the API leaves them out
+	RequiresVerbal        bool
+	RequiresSomatic       bool
+	RequiresMaterial      bool
+	CanBeCastAsRitual     bool
+	RequiresConcentration bool
+	// level as a number that the API sends next to Level, 0 if it doesn't
+	SpellLevel int
+	// fields of the API that litch doesn't know about. They are kept so that
+	// they survive re-caching
+	Extra map[string]json.RawMessage `json:",omitempty"`
+}
+
+// Document is a publication spells are from, per example the SRD
+type Document struct {
+	Slug       string
+	Title      string
+	LicenseURL string
+	URL        string
+}
+
+// HasClass reports whether the spell can be cast by a class or a subclass
+// whose name contains the given lowercase string
+func (s *Spell) HasClass(class string) bool {
+	// appending subclasses to classes could write into the spare capacity
+	// of s.Classes, so they are checked one after the other
+	for _, names := range [][]struct{ Name string }{s.Classes, s.Subclasses} {
+		for _, c := range names {
+			if strings.Contains(strings.ToLower(c.Name), class) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 type SpellAPI struct {
@@ -43,62 +92,226 @@ type SpellAPI struct {
 }
 
 type SpellTemp struct {
-	Index         string `json:"slug"`
-	Name          string
-	Desc          string
-	HigherLevel   string `json:"higher_level"`
-	Range         string
-	Components    string
-	Material      string
-	Ritual        string
-	Duration      string
-	Concentration string
-	CastingTime   string `json:"casting_time"`
-	Level         int    `json:"level_int"` // api has level and level_int
-	School        string
-	Class         string `json:"dnd_class"`
-	Archetype     string
-	Circles       string
+	Index              string `json:"slug"`
+	Name               string
+	Desc               string
+	HigherLevel        string `json:"higher_level"`
+	Range              string
+	Components         string
+	Material           string
+	Ritual             string
+	Duration           string
+	Concentration      string
+	CastingTime        string `json:"casting_time"`
+	Level              int    `json:"level_int"` // api has level and level_int
+	School             string
+	Class              string `json:"dnd_class"`
+	Archetype          string
+	Circles            string
+	DocumentSlug       string   `json:"document__slug"`
+	DocumentTitle      string   `json:"document__title"`
+	DocumentLicenseURL string   `json:"document__license_url"`
+	DocumentURL        string   `json:"document__url"`
+	Page               string   `json:"page"`
+	LevelName          string   `json:"level"`
+	SpellLevel         int      `json:"spell_level"`
+	SpellLists         []string `json:"spell_lists"`
+	TargetRangeSort    int      `json:"target_range_sort"`
+	// flags which duplicate components, ritual and concentration
+	RequiresVerbal        flexBool `json:"requires_verbal_components"`
+	RequiresSomatic       flexBool `json:"requires_somatic_components"`
+	RequiresMaterial      flexBool `json:"requires_material_components"`
+	CanBeCastAsRitual     flexBool `json:"can_be_cast_as_ritual"`
+	RequiresConcentration flexBool `json:"requires_concentration"`
+	// fields of the API which aren't listed above
+	Extra map[string]json.RawMessage `json:"-"`
 }
 
-// Modified during testing
-var fetchFunc func(string) ([]byte, error) = __fetchSpellsAPI
+// Validators are HTTP cache validators of a response. They are sent back
+// with a conditional request to check whether the resource has changed.
+type Validators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
 
-func fetchSpells(url string, data *Spells) error {
-	allSpells := Spells{}
+// apiPage is a single response of the API
+type apiPage struct {
+	body       []byte
+	validators Validators
+	// true if the request was conditional and the resource hasn't changed
+	notModified bool
+}
 
-	for url != "" {
-		body, err := fetchFunc(url)
+// PageFetcher fetches single pages of APIs. It is passed down to everything
+// that fetches spells, so tests can serve pages of their own.
+type PageFetcher struct {
+	// fetches the page at url. If cond isn't empty, the request is
+	// conditional and an unchanged page is reported with notModified
+	Get func(ctx context.Context, url string, cond Validators) (*apiPage, error)
+	// how long fetching all pages of a source can take
+	TotalTimeout time.Duration
+}
+
+// Returns a PageFetcher which fetches pages with the client
+func NewPageFetcher(c *APIClient) *PageFetcher {
+	return &PageFetcher{c.Get, c.TotalTimeout}
+}
+
+// Number of pages of the API that are fetched at once
+var fetchWorkers = 6
+
+// Fetches all pages of spells starting from url. Once the first page tells
+// how many spells there are, the remaining pages are fetched concurrently.
+// Returns the validators of the first page which can be used to check
+// whether the spells have changed. Fetching is stopped when ctx is done or
+// after the total timeout of pages. progress is called after each page, it
+// can be nil.
+func fetchSpells(ctx context.Context, pages *PageFetcher, url string, data *Spells, progress func(FetchProgress)) (Validators, error) {
+	ctx, cancel := context.WithTimeout(ctx, pages.TotalTimeout)
+	defer cancel()
+
+	page, err := pages.Get(ctx, url, Validators{})
+	if err != nil {
+		return Validators{}, err
+	}
+	validators := page.validators
+	first, err := decodeSpellPage(page.body)
+	if err != nil {
+		return validators, err
+	}
+	fetched := []*SpellAPI{first}
+	counter := newProgressCounter(first.Count, len(first.Results), progress)
+	counter.add(len(first.Results))
+
+	// APIs whose next links don't have page numbers are followed one by one
+	next := first.Next
+	if urls := pageURLs(next, first.Count, len(first.Results)); urls != nil {
+		rest, err := fetchPages(ctx, pages, urls, counter)
+		if err != nil {
+			return validators, err
+		}
+		fetched = append(fetched, rest...)
+		// spells could have been added while the pages were fetched
+		next = fetched[len(fetched)-1].Next
+	}
+	for next != "" {
+		page, err := pages.Get(ctx, next, Validators{})
+		if err != nil {
+			return validators, err
+		}
+		jsonResp, err := decodeSpellPage(page.body)
 		if err != nil {
-			fmt.Println("cannot read response:", err)
-			return err
+			return validators, err
 		}
+		fetched = append(fetched, jsonResp)
+		counter.add(len(jsonResp.Results))
+		next = jsonResp.Next
+	}
+
+	allSpells := Spells{}
+	for _, p := range fetched {
+		standard := spellAPIToStandard(&p.Results)
+		allSpells = append(allSpells, *standard...)
+	}
+	*data = allSpells
+	return validators, nil
+}
 
-		var jsonResp SpellAPI
-		if err := json.Unmarshal(body, &jsonResp); err != nil {
-			if e, ok := err.(*json.SyntaxError); ok {
-				return fmt.Errorf("Cannot parse json: %s, at byte offset %d", err, e.Offset)
+// Fetches the pages at urls with at most fetchWorkers requests at once. The
+// pages are returned in the same order as urls. The first error stops
+// fetching of the other pages.
+func fetchPages(ctx context.Context, fetcher *PageFetcher, urls []string, counter *progressCounter) ([]*SpellAPI, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pages := make([]*SpellAPI, len(urls))
+	errs := make([]error, len(urls))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < fetchWorkers && w < len(urls); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				page, err := fetcher.Get(ctx, urls[i], Validators{})
+				if err == nil {
+					pages[i], err = decodeSpellPage(page.body)
+				}
+				if err != nil {
+					errs[i] = err
+					cancel()
+					continue
+				}
+				counter.add(len(pages[i].Results))
 			}
-			return fmt.Errorf("Cannot parse json: %s", err)
+		}()
+	}
+	for i := range urls {
+		if ctx.Err() != nil {
+			break
 		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
 
-		url = jsonResp.Next
+	// the first error is the cause, the others are cancellations
+	var firstErr error
+	for _, err := range errs {
+		if err != nil && (firstErr == nil || errors.Is(firstErr, context.Canceled)) {
+			firstErr = err
+		}
+	}
+	if firstErr == nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return pages, firstErr
+}
 
-		standard := spellAPIToStandard(&jsonResp.Results)
-		allSpells = append(allSpells, *standard...)
+// Returns URLs of all pages after the first one, given the link to the second
+// page, the total count of spells and the size of a page. Returns nil if the
+// link doesn't have a page number.
+func pageURLs(next string, count, pageSize int) []string {
+	u, err := neturl.Parse(next)
+	if err != nil || pageSize == 0 || !u.IsAbs() {
+		return nil
+	}
+	query := u.Query()
+	if n, err := strconv.Atoi(query.Get("page")); err != nil || n != 2 {
+		return nil
+	}
+	var urls []string
+	pageCount := (count + pageSize - 1) / pageSize
+	for n := 2; n <= pageCount; n++ {
+		query.Set("page", strconv.Itoa(n))
+		u.RawQuery = query.Encode()
+		urls = append(urls, u.String())
 	}
+	return urls
+}
 
-	*data = allSpells
-	return nil
+func decodeSpellPage(body []byte) (*SpellAPI, error) {
+	var jsonResp SpellAPI
+	if err := json.Unmarshal(body, &jsonResp); err != nil {
+		if e, ok := err.(*json.SyntaxError); ok {
+			return nil, fmt.Errorf("Cannot parse json: %s, at byte offset %d", err, e.Offset)
+		}
+		return nil, fmt.Errorf("Cannot parse json: %s", err)
+	}
+	return &jsonResp, nil
 }
 
-func __fetchSpellsAPI(url string) ([]byte, error) {
-	r, err := http.Get(url)
+// Checks whether the spells at url have changed since the response with the
+// given validators was received, using a conditional request. If there are
+// no validators, they are always considered changed.
+func spellsChanged(ctx context.Context, pages *PageFetcher, url string, v Validators) (bool, error) {
+	if v == (Validators{}) {
+		return true, nil
+	}
+	page, err := pages.Get(ctx, url, v)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	defer r.Body.Close()
-	return io.ReadAll(r.Body)
+	return !page.notModified, nil
 }
 
 func spellAPIToStandard(spells *[]SpellTemp) *Spells {
@@ -129,6 +342,35 @@ func spellAPIToStandard(spells *[]SpellTemp) *Spells {
 		s.CastingTime = spell.CastingTime
 		s.Level = spell.Level
 		s.School = struct{ Name string }{spell.School}
+		s.Document = Document{spell.DocumentSlug, spell.DocumentTitle, spell.DocumentLicenseURL, spell.DocumentURL}
+		s.Page = spell.Page
+		s.LevelName = spell.LevelName
+		s.SpellLists = spell.SpellLists
+		s.RangeSort = spell.TargetRangeSort
+		s.Extra = spell.Extra
+		s.RequiresVerbal = bool(spell.RequiresVerbal)
+		s.RequiresSomatic = bool(spell.RequiresSomatic)
+		s.RequiresMaterial = bool(spell.RequiresMaterial)
+		s.CanBeCastAsRitual = bool(spell.CanBeCastAsRitual)
+		s.RequiresConcentration = bool(spell.RequiresConcentration)
+		s.SpellLevel = spell.SpellLevel
+		// the flags are used only if the fields they duplicate are missing
+		if len(s.Components) == 0 {
+			if s.RequiresVerbal {
+				s.Components = append(s.Components, "V")
+			}
+			if s.RequiresSomatic {
+				s.Components = append(s.Components, "S")
+			}
+			if s.RequiresMaterial {
+				s.Components = append(s.Components, "M")
+			}
+		}
+		s.Ritual = s.Ritual || s.CanBeCastAsRitual
+		s.Concentration = s.Concentration || s.RequiresConcentration
+		if s.Level == 0 && s.SpellLevel > 0 {
+			s.Level = s.SpellLevel
+		}
 		for _, class := range strings.Split(spell.Class, ",") {
 			class = strings.TrimSpace(class)
 			if class != "Ritual Caster" {
@@ -140,12 +382,12 @@ func spellAPIToStandard(spells *[]SpellTemp) *Spells {
 			for _, arch := range strings.Split(spell.Archetype, ",") {
 				arch = strings.TrimSpace(arch)
 				parts := strings.Split(arch, ": ")
-                // if there are at least 2 parts, continue, if there are not,
-                // there is a problem in parsing without a doubt
-                if len(parts) >= 2 {
-                    subclass := parts[0] + " (" + parts[1] + ")"
-                    s.Subclasses = append(s.Subclasses, struct{ Name string }{subclass})
-                }
+				// if there are at least 2 parts, continue, if there are not,
+				// there is a problem in parsing without a doubt
+				if len(parts) >= 2 {
+					subclass := parts[0] + " (" + parts[1] + ")"
+					s.Subclasses = append(s.Subclasses, struct{ Name string }{subclass})
+				}
 			}
 		}
 		if spell.Circles != "" {
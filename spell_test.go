@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strconv"
 	"testing"
+	"time"
 )
 
 var SpellsJSON []string = []string{`{
@@ -27,6 +33,8 @@ var SpellsJSON []string = []string{`{
             "dnd_class": "Druid, Wizard",
             "archetype": "Druid: Swamp",
             "circles": "Swamp",
+            "spell_lists": ["druid", "wizard"],
+            "target_range_sort": 90,
             "document__slug": "wotc-srd"
         },
         {
@@ -90,23 +98,26 @@ var SpellsJSON []string = []string{`{
             "dnd_class": "  Bard,   Druid  ",
             "archetype": "Cleric: Knowledge",
             "circles": "",
+            "spell_lists": ["bard", "druid"],
+            "target_range_sort": 90,
+            "v2_converted_path": "/v2/spells/srd_confusion",
             "document__slug": "wotc-srd"
         }
 	]
 }
 `}
 
-func __fetchSpellsTest(index string) ([]byte, error) {
+func __fetchSpellsTest(ctx context.Context, index string, cond Validators) (*apiPage, error) {
 	ind, err := strconv.Atoi(index)
 	if err != nil {
 		return nil, err
 	}
 	bits := []byte(SpellsJSON[ind-1])
-	return bits, nil
+	return &apiPage{body: bits}, nil
 }
 
 func TestFetchSpells(t *testing.T) {
-	fetchFunc = __fetchSpellsTest
+	pages := &PageFetcher{Get: __fetchSpellsTest, TotalTimeout: time.Minute}
 	var test = struct {
 		url      string
 		dataAPI  []string
@@ -120,7 +131,7 @@ func TestFetchSpells(t *testing.T) {
 	}
 
 	var output Spells
-	err := fetchSpells(test.url, &output)
+	_, err := fetchSpells(context.Background(), pages, test.url, &output, nil)
 	if err != nil {
 		t.Fatalf("Got error: \"%s\", but expected nil", err)
 	}
@@ -152,3 +163,112 @@ func TestSpellAPIToStandard(t *testing.T) {
 		}
 	}
 }
+
+// Returns a server with count spells split in pages of pageSize, like the
+// Open5e API. If paged is false, next links don't have page numbers so they
+// have to be followed one by one. Each request takes at least delay.
+func newSpellServer(count, pageSize int, paged bool, delay time.Duration) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		param := "page"
+		if !paged {
+			param = "cursor"
+		}
+		n, err := strconv.Atoi(r.URL.Query().Get(param))
+		if err != nil {
+			n = 1
+		}
+		page := SpellAPI{Count: count}
+		for i := (n - 1) * pageSize; i < n*pageSize && i < count; i++ {
+			index := fmt.Sprintf("spell-%04d", i)
+			page.Results = append(page.Results, SpellTemp{Index: index, Name: index, Level: i % 10})
+		}
+		if n*pageSize < count {
+			page.Next = fmt.Sprintf("%s/spells/?%s=%d", server.URL, param, n+1)
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	return server
+}
+
+func TestFetchSpellsParallel(t *testing.T) {
+	pages := NewPageFetcher(NewAPIClient())
+
+	var tests = []struct {
+		count    int
+		pageSize int
+	}{
+		{321, 50},
+		{300, 50},
+		{10, 50},
+		{0, 50},
+	}
+	for _, test := range tests {
+		sequential := newSpellServer(test.count, test.pageSize, false, 0)
+		parallel := newSpellServer(test.count, test.pageSize, true, 0)
+
+		var want, output Spells
+		if _, err := fetchSpells(context.Background(), pages, sequential.URL+"/spells/", &want, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fetchSpells(context.Background(), pages, parallel.URL+"/spells/", &output, nil); err != nil {
+			t.Fatal(err)
+		}
+		sequential.Close()
+		parallel.Close()
+
+		if len(output) != test.count {
+			t.Errorf("Expected %d spells, but got %d", test.count, len(output))
+		}
+		if !reflect.DeepEqual(output, want) {
+			t.Errorf("Spells fetched in parallel differ from sequentially fetched ones")
+		}
+	}
+}
+
+func TestPageURLs(t *testing.T) {
+	var tests = []struct {
+		next  string
+		count int
+		want  []string
+	}{
+		{"https://api.open5e.com/spells/?page=2", 120, []string{
+			"https://api.open5e.com/spells/?page=2",
+			"https://api.open5e.com/spells/?page=3",
+		}},
+		{"https://api.open5e.com/spells/?limit=50&page=2", 101, []string{
+			"https://api.open5e.com/spells/?limit=50&page=2",
+			"https://api.open5e.com/spells/?limit=50&page=3",
+		}},
+		{"https://api.open5e.com/spells/?page=3", 120, nil},
+		{"https://api.open5e.com/spells/?cursor=abc", 120, nil},
+		{"2", 120, nil},
+	}
+	for _, test := range tests {
+		if output := pageURLs(test.next, test.count, 50); !reflect.DeepEqual(output, test.want) {
+			t.Errorf("Unexpected result.\nhave: \"%v\"\nwant: \"%v\"", output, test.want)
+		}
+	}
+}
+
+func BenchmarkFetchSpells(b *testing.B) {
+	pages := NewPageFetcher(NewAPIClient())
+
+	for _, paged := range []bool{false, true} {
+		name := "sequential"
+		if paged {
+			name = "parallel"
+		}
+		b.Run(name, func(b *testing.B) {
+			server := newSpellServer(321, 50, paged, 5*time.Millisecond)
+			defer server.Close()
+			for i := 0; i < b.N; i++ {
+				var output Spells
+				if _, err := fetchSpells(context.Background(), pages, server.URL+"/spells/", &output, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// SpellbookEntry is a single spell in a spellbook. It is referenced by its
+// index, so it doesn't matter from which source the spell comes from.
+type SpellbookEntry struct {
+	Index    string `json:"index"`
+	Known    bool   `json:"known"`
+	Prepared bool   `json:"prepared"`
+}
+
+// Spellbook is a named list of spells, most commonly those of a single
+// character.
+type Spellbook struct {
+	Name   string           `json:"name"`
+	Spells []SpellbookEntry `json:"spells"`
+}
+
+// Entry returns the entry of the spell with the given index, nil if the spell
+// isn't in the book
+func (b *Spellbook) Entry(index string) *SpellbookEntry {
+	for i := range b.Spells {
+		if b.Spells[i].Index == index {
+			return &b.Spells[i]
+		}
+	}
+	return nil
+}
+
+// Add adds a spell to the book. Returns false if it is already there.
+func (b *Spellbook) Add(index string) bool {
+	if b.Entry(index) != nil {
+		return false
+	}
+	b.Spells = append(b.Spells, SpellbookEntry{Index: index})
+	return true
+}
+
+// Remove removes a spell from the book. Returns false if it wasn't there.
+func (b *Spellbook) Remove(index string) bool {
+	for i := range b.Spells {
+		if b.Spells[i].Index == index {
+			b.Spells = append(b.Spells[:i], b.Spells[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Spellbooks holds all spellbooks and saves each of them as a separate JSON
+// file in a directory.
+type Spellbooks struct {
+	dir   string
+	books map[string]*Spellbook
+}
+
+// LoadSpellbooks loads all spellbooks from the directory. The directory
+// doesn't have to exist, in which case there are no books.
+func LoadSpellbooks(dir string) (*Spellbooks, error) {
+	books := Spellbooks{dir, map[string]*Spellbook{}}
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return &books, nil
+	}
+	if err != nil {
+		return &books, err
+	}
+	for _, f := range files {
+		if f.IsDir() || path.Ext(f.Name()) != ".json" {
+			continue
+		}
+		var book Spellbook
+		if err := loadJSONFromFile(path.Join(dir, f.Name()), &book); err != nil {
+			return &books, fmt.Errorf("cannot load spellbook %s: %s", f.Name(), err)
+		}
+		if book.Name == "" {
+			book.Name = strings.TrimSuffix(f.Name(), ".json")
+		}
+		books.books[strings.ToLower(book.Name)] = &book
+	}
+	return &books, nil
+}
+
+// Get returns the book with the given name regardless of its capitalisation,
+// nil if there isn't one
+func (b *Spellbooks) Get(name string) *Spellbook {
+	return b.books[strings.ToLower(name)]
+}
+
+// GetOrCreate returns the book with the given name, creating an empty one if
+// it doesn't exist. The new book is not saved until Save is called. A book
+// can't be created if it would be saved in the file of another book, per
+// example "My/Book" and "My Book".
+func (b *Spellbooks) GetOrCreate(name string) (*Spellbook, error) {
+	if book := b.Get(name); book != nil {
+		return book, nil
+	}
+	for _, other := range b.books {
+		if b.path(other.Name) == b.path(name) {
+			return nil, fmt.Errorf("Spellbook %s would be saved in the same file as %s", name, other.Name)
+		}
+	}
+	book := &Spellbook{Name: name}
+	b.books[strings.ToLower(name)] = book
+	return book, nil
+}
+
+// Names returns names of all books, sorted
+func (b *Spellbooks) Names() []string {
+	var names []string
+	for _, book := range b.books {
+		names = append(names, book.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save writes the book with the given name to the disk
+func (b *Spellbooks) Save(name string) error {
+	book := b.Get(name)
+	if book == nil {
+		return fmt.Errorf("No spellbook named %s", name)
+	}
+	if err := readyDir(b.dir); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(book, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(b.path(name), data, 0644)
+}
+
+// Delete removes the book with the given name from the disk
+func (b *Spellbooks) Delete(name string) error {
+	if b.Get(name) == nil {
+		return fmt.Errorf("No spellbook named %s", name)
+	}
+	if err := os.Remove(b.path(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(b.books, strings.ToLower(name))
+	return nil
+}
+
+// Returns the path of the file of a book
+func (b *Spellbooks) path(name string) string {
+	return path.Join(b.dir, safeFileName(name)+".json")
+}
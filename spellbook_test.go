@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpellbook(t *testing.T) {
+	book := Spellbook{Name: "Gandalf"}
+	if !book.Add("fireball") || !book.Add("light") {
+		t.Fatalf("Could not add spells to an empty book")
+	}
+	if book.Add("fireball") {
+		t.Errorf("Added the same spell twice")
+	}
+	book.Entry("light").Prepared = true
+	if !book.Remove("fireball") || book.Remove("fireball") {
+		t.Errorf("Unexpected result of removing a spell")
+	}
+	want := []SpellbookEntry{{Index: "light", Prepared: true}}
+	if !reflect.DeepEqual(book.Spells, want) {
+		t.Errorf("Unexpected spells.\nhave: \"%#v\"\nwant: \"%#v\"", book.Spells, want)
+	}
+}
+
+func TestSpellbooksPersistence(t *testing.T) {
+	dir := t.TempDir()
+	books, err := LoadSpellbooks(dir + "/spellbooks")
+	if err != nil {
+		t.Fatalf("Got error: \"%s\" while loading from a missing dir", err)
+	}
+
+	for _, name := range []string{"Gandalf the Grey", "Elminster"} {
+		if _, err := books.GetOrCreate(name); err != nil {
+			t.Fatalf("Got error: \"%s\" while creating %s", err, name)
+		}
+	}
+	books.Get("Gandalf the Grey").Add("fireball")
+	books.Get("Elminster").Add("wish")
+	// names that differ only in characters which can't be in file names
+	// would share a file
+	if _, err := books.GetOrCreate("Gandalf/the/Grey"); err == nil {
+		t.Errorf("Created a book in the file of another one")
+	}
+	if book, err := books.GetOrCreate("elminster"); err != nil || book != books.Get("Elminster") {
+		t.Errorf("Expected the existing book, got %v, %v", book, err)
+	}
+	books.Get("elminster").Entry("wish").Known = true
+	for _, name := range books.Names() {
+		if err := books.Save(name); err != nil {
+			t.Fatalf("Got error: \"%s\" while saving %s", err, name)
+		}
+	}
+	if err := books.Delete("gandalf the grey"); err != nil {
+		t.Fatalf("Got error: \"%s\" while deleting", err)
+	}
+	if err := books.Delete("Saruman"); err == nil {
+		t.Errorf("Deleted a book that doesn't exist")
+	}
+
+	loaded, err := LoadSpellbooks(dir + "/spellbooks")
+	if err != nil {
+		t.Fatalf("Got error: \"%s\" while loading", err)
+	}
+	if names := loaded.Names(); !reflect.DeepEqual(names, []string{"Elminster"}) {
+		t.Errorf("Unexpected books loaded: %v", names)
+	}
+	if !reflect.DeepEqual(loaded.Get("Elminster"), books.Get("Elminster")) {
+		t.Errorf("Loaded book differs.\nhave: \"%#v\"\nwant: \"%#v\"", loaded.Get("Elminster"), books.Get("Elminster"))
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// This file contains tolerant JSON decoding of Spell. Custom spells are
+// written by hand, so besides the format in which spells are cached, the
+// format described in README and the one of the dnd5eapi are accepted too.
+
+// UnmarshalJSON decodes a spell in any of the accepted formats and
+// normalises it
+func (s *Spell) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Index         string
+		Name          string
+		Desc          flexText
+		HigherLevel   flexText `json:"higher_level"`
+		HigherLevel2  flexText `json:"HigherLevel"`
+		Range         string
+		Components    flexList
+		Material      string
+		Ritual        flexBool
+		Duration      string
+		Concentration flexBool
+		CastingTime   string `json:"casting_time"`
+		CastingTime2  string `json:"CastingTime"`
+		Level         int
+		School        flexName
+		Classes       flexNames
+		Subclasses    flexNames
+		Document      Document
+		Page          string
+		LevelName     string
+		SpellLists    []string
+		RangeSort     int
+		// flags of the API
+		RequiresVerbal        bool
+		RequiresSomatic       bool
+		RequiresMaterial      bool
+		CanBeCastAsRitual     bool
+		RequiresConcentration bool
+		SpellLevel            int
+		Extra                 map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*s = Spell{
+		Index:         strings.TrimSpace(raw.Index),
+		Name:          strings.TrimSpace(raw.Name),
+		Desc:          string(raw.Desc),
+		HigherLevel:   string(raw.HigherLevel),
+		Range:         raw.Range,
+		Material:      raw.Material,
+		Ritual:        bool(raw.Ritual),
+		Duration:      raw.Duration,
+		Concentration: bool(raw.Concentration),
+		CastingTime:   raw.CastingTime,
+		Level:         raw.Level,
+		School:        struct{ Name string }{string(raw.School)},
+		Document:      raw.Document,
+		Page:          raw.Page,
+		LevelName:     raw.LevelName,
+		SpellLists:    raw.SpellLists,
+		RangeSort:     raw.RangeSort,
+		Extra:         raw.Extra,
+
+		RequiresVerbal:        raw.RequiresVerbal,
+		RequiresSomatic:       raw.RequiresSomatic,
+		RequiresMaterial:      raw.RequiresMaterial,
+		CanBeCastAsRitual:     raw.CanBeCastAsRitual,
+		RequiresConcentration: raw.RequiresConcentration,
+		SpellLevel:            raw.SpellLevel,
+	}
+	if s.HigherLevel == "" {
+		s.HigherLevel = string(raw.HigherLevel2)
+	}
+	if s.CastingTime == "" {
+		s.CastingTime = raw.CastingTime2
+	}
+	for _, c := range raw.Components {
+		s.Components = append(s.Components, strings.ToUpper(c))
+	}
+	for _, c := range raw.Classes {
+		s.Classes = append(s.Classes, struct{ Name string }{c})
+	}
+	for _, c := range raw.Subclasses {
+		s.Subclasses = append(s.Subclasses, struct{ Name string }{c})
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes a spell of the Open5e API. Fields which aren't in
+// SpellTemp are kept in Extra.
+func (s *SpellTemp) UnmarshalJSON(data []byte) error {
+	// the alias has no methods, so decoding into it doesn't recurse
+	type plain SpellTemp
+	if err := json.Unmarshal(data, (*plain)(s)); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	s.Extra = nil
+	for key, value := range fields {
+		if _, ok := spellTempKeys[strings.ToLower(key)]; ok {
+			continue
+		}
+		if s.Extra == nil {
+			s.Extra = map[string]json.RawMessage{}
+		}
+		s.Extra[key] = value
+	}
+	return nil
+}
+
+// Lowercased JSON keys of the fields of SpellTemp. Keys are matched case
+// insensitively like encoding/json does.
+var spellTempKeys = func() map[string]struct{} {
+	keys := map[string]struct{}{}
+	t := reflect.TypeOf(SpellTemp{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		keys[strings.ToLower(name)] = struct{}{}
+	}
+	return keys
+}()
+
+// Returns whether the raw JSON value is null
+func isNull(data []byte) bool {
+	return bytes.Equal(bytes.TrimSpace(data), []byte("null"))
+}
+
+// flexText is either a string or an array of strings, each of which is
+// a paragraph. Paragraphs are joined with blank lines.
+type flexText string
+
+func (t *flexText) UnmarshalJSON(data []byte) error {
+	if isNull(data) {
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*t = flexText(str)
+		return nil
+	}
+	var paragraphs []string
+	if err := json.Unmarshal(data, &paragraphs); err != nil {
+		return fmt.Errorf("expected a string or an array of strings, got %s", jsonKind(data))
+	}
+	*t = flexText(strings.Join(paragraphs, "\n\n"))
+	return nil
+}
+
+// flexName is either a string or an object with a name, per example
+// "Evocation" or {"name": "Evocation"}
+type flexName string
+
+func (n *flexName) UnmarshalJSON(data []byte) error {
+	if isNull(data) {
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*n = flexName(strings.TrimSpace(str))
+		return nil
+	}
+	var obj struct{ Name string }
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return fmt.Errorf("expected a string or an object with a name, got %s", jsonKind(data))
+	}
+	*n = flexName(strings.TrimSpace(obj.Name))
+	return nil
+}
+
+// flexNames is either an array of flexNames or a comma separated string
+type flexNames []string
+
+func (n *flexNames) UnmarshalJSON(data []byte) error {
+	if isNull(data) {
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*n = splitList(str)
+		return nil
+	}
+	var names []flexName
+	if err := json.Unmarshal(data, &names); err != nil {
+		return fmt.Errorf("expected an array of names or a comma separated string, got %s", jsonKind(data))
+	}
+	*n = nil
+	for _, name := range names {
+		if name != "" {
+			*n = append(*n, string(name))
+		}
+	}
+	return nil
+}
+
+// flexList is either an array of strings or a comma separated string
+type flexList []string
+
+func (l *flexList) UnmarshalJSON(data []byte) error {
+	if isNull(data) {
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*l = splitList(str)
+		return nil
+	}
+	var items []string
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("expected an array of strings or a comma separated string, got %s", jsonKind(data))
+	}
+	*l = nil
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// flexBool is either a bool or "yes"/"no" like in the Open5e API
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	if isNull(data) {
+		return nil
+	}
+	var v bool
+	if err := json.Unmarshal(data, &v); err == nil {
+		*b = flexBool(v)
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		switch strings.ToLower(strings.TrimSpace(str)) {
+		case "yes", "true":
+			*b = true
+			return nil
+		case "no", "false", "":
+			*b = false
+			return nil
+		}
+	}
+	return fmt.Errorf("expected a bool or \"yes\"/\"no\", got %s", jsonKind(data))
+}
+
+// Splits a comma separated string and trims its items
+func splitList(str string) []string {
+	var items []string
+	for _, item := range strings.Split(str, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Returns the kind of a raw JSON value for error messages
+func jsonKind(data []byte) string {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return "nothing"
+	}
+	switch data[0] {
+	case '{':
+		return "an object"
+	case '[':
+		return "an array"
+	case '"':
+		return "a string"
+	case 't', 'f':
+		return "a bool"
+	case 'n':
+		return "null"
+	}
+	return "a number"
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSpellUnmarshalJSON(t *testing.T) {
+	var tests = []struct {
+		json    string
+		want    Spell
+		wantErr bool
+	}{
+		// the format from README
+		{`{
+			"index": "magical-spell",
+			"name": "Magical Spell",
+			"desc": ["This spell does magical magic.", "The spell ends when you say \"hocus pocus\"."],
+			"higher_level": ["When you cast this spell using a spell slot of 3rd level and blah blah."],
+			"range": "60 ft",
+			"components": ["V", "S", "M"],
+			"material": "Cat fur",
+			"ritual": false,
+			"duration": "1 hour",
+			"concentration": true,
+			"casting_time": "1 action",
+			"level": 2,
+			"school": {"name": "Abjuration"},
+			"classes": [{"name": "Cleric"}],
+			"subclasses": [{"name": "Lore"}]
+		}`, Spell{Index: "magical-spell", Name: "Magical Spell", Desc: "This spell does magical magic.\n\nThe spell ends when you say \"hocus pocus\".", HigherLevel: "When you cast this spell using a spell slot of 3rd level and blah blah.", Range: "60 ft", Components: []string{"V", "S", "M"}, Material: "Cat fur", Duration: "1 hour", Concentration: true, CastingTime: "1 action", Level: 2, School: struct{ Name string }{"Abjuration"}, Classes: []struct{ Name string }{{"Cleric"}}, Subclasses: []struct{ Name string }{{"Lore"}}}, false},
+		// the format of the cache
+		{`{"Index": "fog", "Name": "Fog", "Desc": "Fog", "HigherLevel": "More fog", "Components": ["V"], "casting_time": "1 action", "School": {"Name": "Conjuration"}, "Classes": [{"Name": "Druid"}], "Subclasses": null}`,
+			Spell{Index: "fog", Name: "Fog", Desc: "Fog", HigherLevel: "More fog", Components: []string{"V"}, CastingTime: "1 action", School: struct{ Name string }{"Conjuration"}, Classes: []struct{ Name string }{{"Druid"}}}, false},
+		// strings instead of arrays and objects
+		{`{"index": "fog", "components": "v, S , M", "school": "Conjuration", "classes": "Druid, Ranger", "ritual": "yes", "concentration": "no"}`,
+			Spell{Index: "fog", Components: []string{"V", "S", "M"}, Ritual: true, School: struct{ Name string }{"Conjuration"}, Classes: []struct{ Name string }{{"Druid"}, {"Ranger"}}}, false},
+		{`{"index": "fog", "classes": ["Druid", {"name": "Ranger"}]}`,
+			Spell{Index: "fog", Classes: []struct{ Name string }{{"Druid"}, {"Ranger"}}}, false},
+		{`{"index": "fog", "desc": 5}`, Spell{}, true},
+		{`{"index": "fog", "ritual": "maybe"}`, Spell{}, true},
+		{`{"index": "fog", "school": ["Conjuration"]}`, Spell{}, true},
+	}
+
+	for _, test := range tests {
+		var output Spell
+		err := json.Unmarshal([]byte(test.json), &output)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Unexpected error for %s: %v", test.json, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(output, test.want) {
+			t.Errorf("Unexpected result.\nhave: \"%#v\"\nwant: \"%#v\"", output, test.want)
+		}
+	}
+}
+
+func TestSpellCacheRoundTrip(t *testing.T) {
+	data, err := json.MarshalIndent(ExampleSpells, "", "    ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output Spells
+	if err := json.Unmarshal(data, &output); err != nil {
+		t.Fatalf("Got error: \"%s\", but expected nil", err)
+	}
+	if !reflect.DeepEqual(output, ExampleSpells) {
+		t.Errorf("Data not identical, expected:\n%#v\nbut got:\n%#v\n", ExampleSpells, output)
+	}
+}
+
+func TestSpellTempUnmarshalJSON(t *testing.T) {
+	wizard := []struct{ Name string }{{"Wizard"}}
+	var tests = []struct {
+		json string
+		want Spell
+	}{
+		// flags are used if the fields they duplicate are missing
+		{`{"slug": "fog", "dnd_class": "Wizard", "requires_material_components": true, "requires_verbal_components": "yes", "can_be_cast_as_ritual": true, "requires_concentration": true, "spell_level": 1}`,
+			Spell{Index: "fog", Classes: wizard, Components: []string{"V", "M"}, Ritual: true, Concentration: true, Level: 1,
+				RequiresVerbal: true, RequiresMaterial: true, CanBeCastAsRitual: true, RequiresConcentration: true, SpellLevel: 1}},
+		{`{"slug": "fog", "dnd_class": "Wizard", "requires_material_components": true, "requires_somatic_components": true, "requires_verbal_components": true}`,
+			Spell{Index: "fog", Classes: wizard, Components: []string{"V", "S", "M"}, RequiresVerbal: true, RequiresSomatic: true, RequiresMaterial: true}},
+		{`{"slug": "fog", "dnd_class": "Wizard", "components": "S", "requires_verbal_components": true, "level_int": 2, "spell_level": 1}`,
+			Spell{Index: "fog", Classes: wizard, Components: []string{"S"}, Level: 2, RequiresVerbal: true, SpellLevel: 1}},
+		// unknown fields are kept, known ones regardless of capitalisation
+		{`{"Slug": "fog", "dnd_class": "Wizard", "Page": "phb 243", "document__url": "https://x", "new_field": {"a": 1}, "other": null}`,
+			Spell{Index: "fog", Classes: wizard, Page: "phb 243", Document: Document{URL: "https://x"}, Extra: map[string]json.RawMessage{"new_field": json.RawMessage(`{"a": 1}`), "other": json.RawMessage("null")}}},
+	}
+
+	for _, test := range tests {
+		var temp []SpellTemp
+		if err := json.Unmarshal([]byte("["+test.json+"]"), &temp); err != nil {
+			t.Errorf("Unexpected error for %s: %v", test.json, err)
+			continue
+		}
+		output := (*spellAPIToStandard(&temp))[0]
+		if !reflect.DeepEqual(output, test.want) {
+			t.Errorf("Unexpected result.\nhave: \"%#v\"\nwant: \"%#v\"", output, test.want)
+		}
+		// the spell is cached as it is, raw fields are only compacted
+		data, err := json.Marshal(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cached Spell
+		if err := json.Unmarshal(data, &cached); err != nil {
+			t.Fatal(err)
+		}
+		if again, _ := json.Marshal(cached); !bytes.Equal(again, data) {
+			t.Errorf("Spell changed in the cache.\nhave: %s\nwant: %s", again, data)
+		}
+	}
+}
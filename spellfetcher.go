@@ -1,11 +1,11 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"os"
-	"path"
+	"io/ioutil"
 	"sort"
+	"time"
 )
 
 // TODO: refractor the registering of events, possibly exporting them as consts
@@ -23,16 +23,43 @@ type SpellFetcher struct {
 	// URL of the API from which the spells will be fetched
 	// can be "" if the spells can be found only locally
 	apiURL string
+	// format of the API, or of the local file if there is no API. Local
+	// files of sources with an API are caches which are always in the litch
+	// format
+	format *SpellFormat
 	// data that will be set when Fetch method is called. Afet the method
 	// is called, this must not be nil
 	data *Spells
+	// fetches pages of the API
+	pages *PageFetcher
 	// optional EventRegister where events will be reported
 	evtReg *EventRegister
+	// problems with spells in the local file, which were skipped
+	diagnostics []Diagnostic
+	// when the spells were fetched from the API, zero if unknown
+	fetched time.Time
+	// cache validators of the last API response
+	validators Validators
+	// true if the spells were loaded from a cache of the API
+	fromCache bool
+	// true if the cache was corrupt and its backup was loaded instead
+	fromBackup bool
+	// error of the last fetch from the API, nil if it succeeded
+	err error
+	// key of cacheFormats in which the cache is saved, "" for JSON
+	cacheFormat string
 }
 
-func NewSpellFetcher(name, local, apiUrl string, e *EventRegister) *SpellFetcher {
+// NewSpellFetcher returns a new SpellFetcher. Format must be one of the keys
+// of spellFormats, "" defaults to litch. Pages of the API are fetched with
+// pages.
+func NewSpellFetcher(name, local, apiUrl, format string, pages *PageFetcher, e *EventRegister) *SpellFetcher {
 	data := Spells{}
-	return &SpellFetcher{name, local, apiUrl, &data, e}
+	f, ok := spellFormats[format]
+	if !ok {
+		f = spellFormats["litch"]
+	}
+	return &SpellFetcher{name: name, local: local, apiURL: apiUrl, format: f, data: &data, pages: pages, evtReg: e}
 }
 
 // Fetch the spells from local storage if it exists, if not, fetch them from
@@ -40,10 +67,10 @@ func NewSpellFetcher(name, local, apiUrl string, e *EventRegister) *SpellFetcher
 // This function was designed to be run in a separate goroutine. The fetched
 // spells can be accesed in two ways: through a channel, or via a field in the
 // SpellFetcher struct. If isForce is true, then it will always attempt to refetch
-// the data.
+// the data. Fetching from the API is stopped when ctx is done.
 // DISCLAIMER: no mutex was put for the safety of SpellFetcher.data because it
 // is not expected that the field is acessed at once in any point of time
-func (s *SpellFetcher) FetchSpells(spellCh chan<- Spells, isForce bool) {
+func (s *SpellFetcher) FetchSpells(ctx context.Context, spellCh chan<- Spells, isForce bool) {
 	defer func() {
 		// Sort and pass the data through the channel when the function ends.
 		// If local storage nor API url weren't passed to the SpellFetched,
@@ -55,80 +82,177 @@ func (s *SpellFetcher) FetchSpells(spellCh chan<- Spells, isForce bool) {
 	}()
 
 	// fetch the files if they exist, nothing is cached already and force refetch
-	// wasn't specified
-	if s.local != "" && checkFile(s.local) && !isForce {
-		if err := loadJSONFromFile(s.local, s.data); err != nil {
+	// wasn't specified. Sources without an API can only be loaded from files.
+	// Corrupt caches are refetched.
+	if s.hasLocal() && (!isForce || s.apiURL == "") {
+		err := s.loadLocal()
+		switch {
+		case err != nil && s.apiURL != "":
+			warn := fmt.Sprintf("Cache of %v is corrupt, refetching...", s.name)
+			s.evtReg.Register(EventWarn, fmt.Sprintf("error while loading cache: %v", err), warn)
+			s.fetchAPI(ctx, true)
+			return
+		case err != nil:
 			formatedErr := fmt.Sprintf("error while parsing json: %v", err)
 			status := fmt.Sprintf("Could not parse %v from local file, check logs", s.name)
 			s.evtReg.Register(EventErr, formatedErr, status)
 			return
 		}
+		if len(s.diagnostics) > 0 {
+			for _, d := range s.diagnostics {
+				s.evtReg.Register(EventWarn, d.String(), "")
+			}
+			warn := fmt.Sprintf("Skipped invalid spells in %v, see :diag", s.name)
+			s.evtReg.Register(EventWarn, warn, warn)
+			return
+		}
 		info := fmt.Sprintf("Loaded offline cache for %v", s.name)
+		if s.fromBackup {
+			info = fmt.Sprintf("Cache of %v is corrupt, loaded its backup", s.name)
+		}
+		if s.fromCache {
+			info += fmt.Sprintf(" (cache is %s)", formatAge(cacheAge(s.fetched, time.Now())))
+		}
 		s.evtReg.Register(EventInfo, info, info)
 		return
 	}
 	// fetch the data from the API if it exists and nothing is cached
 	if s.apiURL != "" {
-		if isForce {
-			info := fmt.Sprintf("Refetching %v from remote API...", s.name)
-			s.evtReg.Register(EventInfo, info, info)
-		} else {
-			info := fmt.Sprintf("Could not find %v locally, fetching from remote API...", s.name)
-			s.evtReg.Register(EventInfo, info, info)
-		}
-		if err := fetchSpells(s.apiURL, s.data); err != nil {
-			formatedErr := fmt.Sprintf("error while fetching api: %v", err)
-			status := fmt.Sprintf("Could not fetch %v from remote API", s.name)
-			s.evtReg.Register(EventErr, formatedErr, status)
-		}
-		info := fmt.Sprintf("Fetched online spells for %v", s.name)
-		s.evtReg.Register(EventInfo, info, info)
-		if s.local != "" {
-			info := fmt.Sprintf("Caching %v...", s.name)
-			s.evtReg.Register(EventInfo, info, info)
-			go s.Cache()
-		}
+		s.fetchAPI(ctx, isForce)
 		return
 	}
 	info := fmt.Sprintf("Could not fetch nor find cached data for %s", s.name)
 	s.evtReg.Register(EventInfo, info, "")
 }
 
-// Cache the data that is currently held in s.data. It will panic
-// if s.data is nil which should never happen
-func (s *SpellFetcher) Cache() {
-	if s.data == nil {
-		err := "no data to cache"
-		s.evtReg.Register(EventErr, err, err)
+// Fetches the spells from the API. If that fails, the spells from the cache
+// are kept.
+func (s *SpellFetcher) fetchAPI(ctx context.Context, isForce bool) {
+	if isForce {
+		info := fmt.Sprintf("Refetching %v from remote API...", s.name)
+		s.evtReg.Register(EventInfo, info, info)
+	} else {
+		info := fmt.Sprintf("Could not find %v locally, fetching from remote API...", s.name)
+		s.evtReg.Register(EventInfo, info, info)
+	}
+	progress := func(p FetchProgress) {
+		p.Source = s.name
+		s.evtReg.Progress(p)
+	}
+	// the spells are fetched aside so that a failed fetch doesn't
+	// touch the ones that were loaded before
+	var fetched Spells
+	validators, err := s.format.fetch(ctx, s.pages, s.apiURL, &fetched, progress)
+	if err == nil && len(fetched) == 0 {
+		err = errNoSpells
 	}
-
-	// marshall the fetched spells in human-readable format
-	cacheReady, err := json.MarshalIndent(s.data, "", "    ")
 	if err != nil {
-		formatedErr := fmt.Sprintf("error while marshaling %s: %s", s.name, err)
-		status := fmt.Sprintf("Error while trying to cache %s, check logs", err)
-		s.evtReg.Register(EventErr, formatedErr, status)
+		s.err = err
+		s.evtReg.Register(EventErr, fmt.Sprintf("error while fetching api: %v", err), "")
+		s.keepCache()
+		return
+	}
+	*s.data = fetched
+	s.fetched, s.validators = time.Now(), validators
+	info := fmt.Sprintf("Fetched online spells for %v", s.name)
+	s.evtReg.Register(EventInfo, info, info)
+	if s.local != "" {
+		info := fmt.Sprintf("Caching %v...", s.name)
+		s.evtReg.Register(EventInfo, info, info)
+		s.Cache()
+	}
+}
+
+// Loads the cache after a failed fetch so that the spells from before are
+// kept, the cache itself is left as it is
+func (s *SpellFetcher) keepCache() {
+	kept := ""
+	if s.hasLocal() {
+		if err := s.loadLocal(); err != nil {
+			s.evtReg.Register(EventErr, fmt.Sprintf("error while parsing json: %v", err), "")
+		} else {
+			kept = ", kept the cached spells"
+		}
 	}
+	status := fetchErrStatus(s.name, s.err) + kept
+	s.evtReg.Register(EventWarn, status, status)
+}
 
-	// make sure that the dir of the file is created
-	if err := readyDir(path.Dir(s.local)); err != nil {
-		formatedErr := fmt.Sprintf("error while creating dir: %s", err)
-		status := fmt.Sprintf("Error while trying to create dir for %s, check logs", err)
-		s.evtReg.Register(EventErr, formatedErr, status)
+// Reports whether there is a local file to load the spells from. Caches are
+// loaded from their backup if they are missing
+func (s *SpellFetcher) hasLocal() bool {
+	if s.local == "" {
+		return false
 	}
+	return checkFile(s.local) || (s.apiURL != "" && checkFile(backupPath(s.local)))
+}
 
-	file, err := os.Create(s.local)
+// Load the spells from the local file. Caches of sources with an API were
+// written by litch, so they are not validated.
+func (s *SpellFetcher) loadLocal() error {
+	if s.apiURL != "" {
+		cache, usedBackup, err := loadCacheOrBackup(s.local)
+		if err != nil {
+			return err
+		}
+		*s.data = cache.Spells
+		s.fetched, s.validators, s.fromCache, s.fromBackup = cache.Fetched, cache.Validators, true, usedBackup
+		// caches from older versions or in other formats than the
+		// configured one are migrated
+		if cache.Version < CacheSchemaVersion || cache.format != s.cacheFormatName() {
+			s.Cache()
+		}
+		return nil
+	}
+	data, err := ioutil.ReadFile(s.local)
 	if err != nil {
-		formatedErr := fmt.Sprintf("error while creating file: %s", err)
-		status := fmt.Sprintf("Error while trying to cache %s, check logs", err)
-		s.evtReg.Register(EventErr, formatedErr, status)
+		return err
 	}
-	defer file.Close()
+	s.diagnostics, err = s.format.decode(s.local, data, s.data)
+	return err
+}
 
-	// cache the spells
-	if _, err := file.Write(cacheReady); err != nil {
-		formatedErr := fmt.Sprintf("error while creating file: %s", err)
-		status := fmt.Sprintf("Error while trying to cache %s, check logs", err)
+// Cache the data that is currently held in s.data. The previous cache is
+// kept as a backup.
+func (s *SpellFetcher) Cache() error {
+	cache := CacheEnvelope{
+		Fetched:    s.fetched,
+		Source:     s.apiURL,
+		Validators: s.validators,
+		Spells:     *s.data,
+	}
+	if err := writeCache(s.local, &cache, s.cacheFormat); err != nil {
+		formatedErr := fmt.Sprintf("error while caching %s: %s", s.name, err)
+		status := fmt.Sprintf("Could not cache %s, check logs", s.name)
 		s.evtReg.Register(EventErr, formatedErr, status)
+		return err
+	}
+	return nil
+}
+
+// Returns the name of the format in which the cache is saved
+func (s *SpellFetcher) cacheFormatName() string {
+	if _, ok := cacheFormats[s.cacheFormat]; !ok {
+		return "json"
+	}
+	return s.cacheFormat
+}
+
+// Stale reports whether the spells were loaded from a cache older than
+// maxAge. Negative maxAge disables the check.
+func (s *SpellFetcher) Stale(maxAge time.Duration, now time.Time) bool {
+	return s.fromCache && maxAge >= 0 && cacheAge(s.fetched, now) > maxAge
+}
+
+// Revalidate checks with a conditional request whether the spells on the API
+// have changed since they were cached. If they haven't, the cache is renewed
+// so that it isn't checked again until it gets stale.
+func (s *SpellFetcher) Revalidate(ctx context.Context) (changed bool, err error) {
+	changed, err = spellsChanged(ctx, s.pages, s.apiURL, s.validators)
+	if err != nil || changed {
+		return changed, err
 	}
+	s.fetched = time.Now()
+	s.Cache()
+	return false, nil
 }
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// This file contains the CSV and TSV formats of spells, made to be edited in
+// spreadsheets. Each row is a spell and the first row names the columns,
+// which can be in any order. Lists like components and classes are joined
+// with commas or semicolons. Diagnostics point to the row and the column of
+// a cell like a spreadsheet does, the header is row 1.
+
+// spellColumn is a column of the CSV and TSV formats
+type spellColumn struct {
+	name string
+	get  func(s *Spell) string
+	// sets the field of the spell from a cell, the cell is already trimmed
+	set func(s *Spell, cell string) error
+}
+
+// All columns in the order they are exported
+var spellColumns = []spellColumn{
+	{"index", func(s *Spell) string { return s.Index }, func(s *Spell, c string) error { s.Index = c; return nil }},
+	{"name", func(s *Spell) string { return s.Name }, func(s *Spell, c string) error { s.Name = c; return nil }},
+	{"level", func(s *Spell) string { return strconv.Itoa(s.Level) }, setLevelCell},
+	{"school", func(s *Spell) string { return s.School.Name }, func(s *Spell, c string) error { s.School.Name = c; return nil }},
+	{"classes", func(s *Spell) string { return joinNames(s.Classes) }, func(s *Spell, c string) error { s.Classes = splitNames(c); return nil }},
+	{"subclasses", func(s *Spell) string { return joinNames(s.Subclasses) }, func(s *Spell, c string) error { s.Subclasses = splitNames(c); return nil }},
+	{"casting_time", func(s *Spell) string { return s.CastingTime }, func(s *Spell, c string) error { s.CastingTime = c; return nil }},
+	{"range", func(s *Spell) string { return s.Range }, func(s *Spell, c string) error { s.Range = c; return nil }},
+	{"components", func(s *Spell) string { return strings.Join(s.Components, ", ") }, func(s *Spell, c string) error {
+		s.Components = nil
+		for _, comp := range splitCell(c) {
+			s.Components = append(s.Components, strings.ToUpper(comp))
+		}
+		return nil
+	}},
+	{"material", func(s *Spell) string { return s.Material }, func(s *Spell, c string) error { s.Material = c; return nil }},
+	{"duration", func(s *Spell) string { return s.Duration }, func(s *Spell, c string) error { s.Duration = c; return nil }},
+	{"ritual", func(s *Spell) string { return yesNo(s.Ritual) }, func(s *Spell, c string) (err error) { s.Ritual, err = parseYesNo(c); return }},
+	{"concentration", func(s *Spell) string { return yesNo(s.Concentration) }, func(s *Spell, c string) (err error) { s.Concentration, err = parseYesNo(c); return }},
+	{"desc", func(s *Spell) string { return s.Desc }, func(s *Spell, c string) error { s.Desc = c; return nil }},
+	{"higher_level", func(s *Spell) string { return s.HigherLevel }, func(s *Spell, c string) error { s.HigherLevel = c; return nil }},
+	{"level_name", func(s *Spell) string { return s.LevelName }, func(s *Spell, c string) error { s.LevelName = c; return nil }},
+	{"spell_lists", func(s *Spell) string { return strings.Join(s.SpellLists, ", ") }, func(s *Spell, c string) error { s.SpellLists = splitCell(c); return nil }},
+	{"range_sort", func(s *Spell) string { return strconv.Itoa(s.RangeSort) }, func(s *Spell, c string) (err error) {
+		if c == "" {
+			s.RangeSort = 0
+			return nil
+		}
+		if s.RangeSort, err = strconv.Atoi(c); err != nil {
+			return fmt.Errorf("expected a number, got %s", c)
+		}
+		return nil
+	}},
+	{"page", func(s *Spell) string { return s.Page }, func(s *Spell, c string) error { s.Page = c; return nil }},
+	{"document", func(s *Spell) string { return s.Document.Slug }, func(s *Spell, c string) error { s.Document.Slug = c; return nil }},
+	{"document_title", func(s *Spell) string { return s.Document.Title }, func(s *Spell, c string) error { s.Document.Title = c; return nil }},
+	{"document_license_url", func(s *Spell) string { return s.Document.LicenseURL }, func(s *Spell, c string) error { s.Document.LicenseURL = c; return nil }},
+	{"document_url", func(s *Spell) string { return s.Document.URL }, func(s *Spell, c string) error { s.Document.URL = c; return nil }},
+}
+
+// Returns the column with the name. Names are matched regardless of
+// capitalisation, and spaces can be used instead of underscores.
+func findSpellColumn(name string) *spellColumn {
+	name = strings.Replace(strings.ToLower(strings.TrimSpace(name)), " ", "_", -1)
+	for i := range spellColumns {
+		if spellColumns[i].name == name {
+			return &spellColumns[i]
+		}
+	}
+	return nil
+}
+
+// Decodes spells from rows of cells whose first row is the header. Invalid
+// spells are skipped and their problems are returned as diagnostics.
+func decodeSpellRows(file string, rows [][]string) (Spells, []Diagnostic) {
+	var spells Spells
+	var diags []Diagnostic
+	report := func(row, col int, format string, a ...interface{}) {
+		diags = append(diags, Diagnostic{file, row, col, fmt.Sprintf(format, a...)})
+	}
+	if len(rows) == 0 {
+		return spells, diags
+	}
+
+	columns := make([]*spellColumn, len(rows[0]))
+	// name of a column -> its number, for problems of spells
+	fieldCols := map[string]int{}
+	for i, name := range rows[0] {
+		if columns[i] = findSpellColumn(name); columns[i] == nil {
+			if strings.TrimSpace(name) != "" {
+				report(1, i+1, "unknown column %s", name)
+			}
+			continue
+		}
+		fieldCols[columns[i].name] = i + 1
+	}
+	if _, ok := fieldCols["index"]; !ok {
+		report(1, 1, "missing the index column")
+		return spells, diags
+	}
+
+	// index -> row where it was first used
+	seen := map[string]int{}
+	for r, cells := range rows[1:] {
+		row := r + 2
+		if isBlankRow(cells) {
+			continue
+		}
+		before := len(diags)
+		var spell Spell
+		for i, cell := range cells {
+			if i >= len(columns) {
+				if strings.TrimSpace(cell) != "" {
+					report(row, i+1, "cell outside of the named columns")
+				}
+				continue
+			}
+			if columns[i] == nil {
+				continue
+			}
+			if err := columns[i].set(&spell, strings.TrimSpace(cell)); err != nil {
+				report(row, i+1, "invalid %s: %s", columns[i].name, err)
+			}
+		}
+		if spell.Index != "" {
+			if first, ok := seen[spell.Index]; ok {
+				report(row, fieldCols["index"], "duplicate index %s, first used in row %d", spell.Index, first)
+			} else {
+				seen[spell.Index] = row
+			}
+		}
+		for _, p := range checkSpell(&spell) {
+			col, ok := fieldCols[p.field]
+			if !ok {
+				col = fieldCols["index"]
+			}
+			report(row, col, "%s", p.message)
+		}
+		if len(diags) == before {
+			spells = append(spells, spell)
+		}
+	}
+	return spells, diags
+}
+
+func isBlankRow(cells []string) bool {
+	for _, c := range cells {
+		if strings.TrimSpace(c) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// spreadsheets often start files with a byte order mark
+var utf8BOM = []byte("\ufeff")
+
+// Decodes spells from CSV. Rows which can't be parsed are reported and
+// skipped.
+func decodeSpellCSV(file string, data []byte) (Spells, []Diagnostic) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, utf8BOM)))
+	// rows can have less cells than the header, spreadsheets leave out
+	// empty cells at the end
+	r.FieldsPerRecord = -1
+	var rows [][]string
+	var diags []Diagnostic
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if e, ok := err.(*csv.ParseError); ok {
+			diags = append(diags, Diagnostic{file, len(rows) + 1, 1, e.Err.Error()})
+			// the row is kept so that rows after it keep their numbers
+			rows = append(rows, nil)
+			continue
+		}
+		if err != nil {
+			diags = append(diags, Diagnostic{file, len(rows) + 1, 1, err.Error()})
+			break
+		}
+		rows = append(rows, record)
+	}
+	spells, rowDiags := decodeSpellRows(file, rows)
+	return spells, append(diags, rowDiags...)
+}
+
+// Decodes spells from TSV. Tabs, newlines and backslashes in cells are
+// escaped as \t, \n and \\.
+func decodeSpellTSV(file string, data []byte) (Spells, []Diagnostic) {
+	text := strings.TrimPrefix(string(data), string(utf8BOM))
+	text = strings.TrimRight(strings.Replace(text, "\r\n", "\n", -1), "\n")
+	var rows [][]string
+	if text != "" {
+		for _, line := range strings.Split(text, "\n") {
+			cells := strings.Split(line, "\t")
+			for i := range cells {
+				cells[i] = tsvUnescape(cells[i])
+			}
+			rows = append(rows, cells)
+		}
+	}
+	return decodeSpellRows(file, rows)
+}
+
+// Writes spells as CSV with a header row
+func writeSpellCSV(w io.Writer, title string, spells Spells) error {
+	cw := csv.NewWriter(w)
+	for _, row := range spellRows(spells) {
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Writes spells as TSV with a header row
+func writeSpellTSV(w io.Writer, title string, spells Spells) error {
+	for _, row := range spellRows(spells) {
+		for i := range row {
+			row[i] = tsvField(row[i])
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(row, "\t")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the header and a row of cells for each spell
+func spellRows(spells Spells) [][]string {
+	header := make([]string, len(spellColumns))
+	for i, c := range spellColumns {
+		header[i] = c.name
+	}
+	rows := [][]string{header}
+	for i := range spells {
+		row := make([]string, len(spellColumns))
+		for j, c := range spellColumns {
+			row[j] = c.get(&spells[i])
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// Reverses tsvField
+func tsvUnescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// Parses a level, "cantrip" is level 0
+func setLevelCell(s *Spell, cell string) error {
+	if cell == "" || strings.EqualFold(cell, "cantrip") {
+		s.Level = 0
+		return nil
+	}
+	level, err := strconv.Atoi(cell)
+	if err != nil {
+		return fmt.Errorf("expected a number or cantrip, got %s", cell)
+	}
+	s.Level = level
+	return nil
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// Parses yes/no like boolean query fields, an empty cell is no
+func parseYesNo(cell string) (bool, error) {
+	switch strings.ToLower(cell) {
+	case "yes", "y", "true", "x":
+		return true, nil
+	case "no", "n", "false", "":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected yes or no, got %s", cell)
+}
+
+// Splits a cell with a list separated by commas or semicolons
+func splitCell(cell string) []string {
+	return splitList(strings.Replace(cell, ";", ",", -1))
+}
+
+func splitNames(cell string) []struct{ Name string } {
+	var names []struct{ Name string }
+	for _, n := range splitCell(cell) {
+		names = append(names, struct{ Name string }{n})
+	}
+	return names
+}
+
+func joinNames(names []struct{ Name string }) string {
+	var s []string
+	for _, n := range names {
+		s = append(s, n.Name)
+	}
+	return strings.Join(s, ", ")
+}
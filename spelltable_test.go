@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSpellTableRoundTrip(t *testing.T) {
+	// unknown API fields are not a part of the tables
+	spells := append(Spells(nil), ExampleSpells...)
+	for i := range spells {
+		spells[i].Extra = nil
+	}
+	spells[1].Desc = "Bubble, \"quoted\"\n\nand\ta tab \\ and a backslash"
+	// imported spells are validated, confusion has a material without M
+	spells[3].Components = []string{"M"}
+
+	for _, format := range []string{"csv", "tsv"} {
+		var data bytes.Buffer
+		if err := exportSpells(&data, format, "Spells", spells); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		var output Spells
+		diags, err := spellFormats[format].decode("spells."+format, data.Bytes(), &output)
+		if err != nil || len(diags) > 0 {
+			t.Fatalf("%s: unexpected problems: %v %v", format, err, diags)
+		}
+		if !reflect.DeepEqual(output, spells) {
+			t.Errorf("%s: data not identical, expected:\n%#v\nbut got:\n%#v\n", format, spells, output)
+		}
+	}
+}
+
+func TestDecodeSpellCSV(t *testing.T) {
+	var tests = []struct {
+		csv   string
+		want  []string
+		diags []string
+	}{
+		// columns in any order with spreadsheet names, missing trailing cells
+		{"\ufeffName,Index,Level,Components,Casting Time,Classes\nFog,fog,1,\"v; s\",1 action,\"Druid; Ranger\"\nMist,mist,cantrip\n",
+			[]string{"fog", "mist"}, nil},
+		{"index,name,level,ritual,material,colour\nfog,Fog,1,maybe\n,Mist,2\nhaze,Haze,10\nfog,Fog again,1\nsmoke,Smoke,1,no,ash\n\nok,Ok,1,x\n",
+			[]string{"ok"}, []string{
+				"spells.csv:1:6: unknown column colour",
+				"spells.csv:2:4: invalid ritual: expected yes or no, got maybe",
+				"spells.csv:3:1: spell \"Mist\" has no index",
+				"spells.csv:4:3: level 10 is not between 0 and 9",
+				"spells.csv:5:1: duplicate index fog, first used in row 2",
+				"spells.csv:6:5: material is set, but M is not one of the components",
+			}},
+		// a broken row doesn't hide the rest
+		{"index,name\nfog,\"Fog\" x\nmist,Mist\n", []string{"mist"}, []string{"spells.csv:2:1: extraneous or missing \" in quoted-field"}},
+		{"name,level\nFog,1\n", nil, []string{"spells.csv:1:1: missing the index column"}},
+		{"", nil, nil},
+	}
+
+	for _, test := range tests {
+		spells, diags := decodeSpellCSV("spells.csv", []byte(test.csv))
+		var output, messages []string
+		for _, s := range spells {
+			output = append(output, s.Index)
+		}
+		for _, d := range diags {
+			messages = append(messages, d.String())
+		}
+		if !reflect.DeepEqual(output, test.want) {
+			t.Errorf("Unexpected spells for %q.\nhave: %v\nwant: %v", test.csv, output, test.want)
+		}
+		if !reflect.DeepEqual(messages, test.diags) {
+			t.Errorf("Unexpected diagnostics for %q.\nhave: %q\nwant: %q", test.csv, messages, test.diags)
+		}
+	}
+}
+
+func TestDecodeSpellTSV(t *testing.T) {
+	spells, diags := decodeSpellTSV("spells.tsv", []byte("index\tname\tdesc\r\nfog\tFog\tThick\\nfog\\twith \\\\ tabs\r\n"))
+	want := Spells{{Index: "fog", Name: "Fog", Desc: "Thick\nfog\twith \\ tabs"}}
+	if len(diags) > 0 || !reflect.DeepEqual(spells, want) {
+		t.Errorf("Unexpected result.\nhave: %#v %v\nwant: %#v", spells, diags, want)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Diagnostic is a problem with a spell in a local file. Line and column
+// point to where the problem is in the file.
+type Diagnostic struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+}
+
+// validComponents are the only components a spell can have
+var validComponents = map[string]bool{"V": true, "S": true, "M": true}
+
+// Decodes a JSON array of spells in the litch format from file and validates
+// every spell. Invalid spells are skipped and all their problems are returned
+// as diagnostics, so a single typo doesn't hide all the other spells. If the
+// file isn't valid JSON, spells up to the syntax error are returned.
+func validateSpells(file string, data []byte) (Spells, []Diagnostic) {
+	v := spellValidator{file: file, data: data, seen: map[string]int{}}
+	return v.validate()
+}
+
+type spellValidator struct {
+	file  string
+	data  []byte
+	diags []Diagnostic
+	// index of spells that were already validated -> offset of the index
+	seen map[string]int
+}
+
+// Adds a diagnostic at the byte offset in the file
+func (v *spellValidator) report(offset int, format string, a ...interface{}) {
+	line, col := lineColumn(v.data, offset)
+	v.diags = append(v.diags, Diagnostic{v.file, line, col, fmt.Sprintf(format, a...)})
+}
+
+func (v *spellValidator) validate() (Spells, []Diagnostic) {
+	var spells Spells
+	dec := json.NewDecoder(bytes.NewReader(v.data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		v.reportErr(dec, err, "expected an array of spells")
+		return spells, v.diags
+	}
+	for dec.More() {
+		start := skipSeparators(v.data, int(dec.InputOffset()))
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			v.reportErr(dec, err, "invalid spell")
+			return spells, v.diags
+		}
+		if spell, ok := v.validateSpell(raw, start); ok {
+			spells = append(spells, spell)
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		v.reportErr(dec, err, "expected the end of the array")
+	}
+	return spells, v.diags
+}
+
+// Reports a decoding error. Syntax errors know where they happened, for the
+// others the current position of the decoder is used.
+func (v *spellValidator) reportErr(dec *json.Decoder, err error, msg string) {
+	offset := int(dec.InputOffset())
+	if e, ok := err.(*json.SyntaxError); ok {
+		offset = int(e.Offset) - 1
+	}
+	if err != nil {
+		v.report(offset, "%s: %s", msg, err)
+		return
+	}
+	v.report(offset, msg)
+}
+
+// Validates a single spell whose raw JSON starts at the offset in the file.
+// ok is false if the spell has any problems.
+func (v *spellValidator) validateSpell(raw json.RawMessage, start int) (spell Spell, ok bool) {
+	before := len(v.diags)
+	keys, err := keyOffsets(raw)
+	if err != nil {
+		v.report(start, "expected a spell object, got %s", jsonKind(raw))
+		return spell, false
+	}
+	// offset of the key in the file, or of the spell if the key is missing
+	at := func(key string) int {
+		if offset, ok := keys[strings.ToLower(key)]; ok {
+			return start + offset
+		}
+		return start
+	}
+
+	if err := json.Unmarshal(raw, &spell); err != nil {
+		// find which field is the culprit by decoding them one by one
+		var fields map[string]json.RawMessage
+		json.Unmarshal(raw, &fields)
+		var names []string
+		for key := range fields {
+			names = append(names, key)
+		}
+		// report in the order the fields appear in the file
+		sort.Slice(names, func(i, j int) bool { return at(names[i]) < at(names[j]) })
+		reported := false
+		for _, key := range names {
+			value := fields[key]
+			var s Spell
+			single, _ := json.Marshal(map[string]json.RawMessage{key: value})
+			if err := json.Unmarshal(single, &s); err != nil {
+				v.report(at(key), "invalid %s: %s", key, typeErrMessage(err))
+				reported = true
+			}
+		}
+		if !reported {
+			v.report(start, "invalid spell: %s", err)
+		}
+		return spell, false
+	}
+
+	if spell.Index != "" {
+		if first, ok := v.seen[spell.Index]; ok {
+			line, col := lineColumn(v.data, first)
+			v.report(at("index"), "duplicate index %s, first used at %d:%d", spell.Index, line, col)
+		} else {
+			v.seen[spell.Index] = at("index")
+		}
+	}
+	for _, p := range checkSpell(&spell) {
+		v.report(at(p.field), "%s", p.message)
+	}
+	return spell, len(v.diags) == before
+}
+
+// spellProblem is a problem with a decoded spell. field is the lowercase name
+// of the field which has the problem, or "" if it's the whole spell.
+type spellProblem struct {
+	field   string
+	message string
+}
+
+// Checks a decoded spell for problems that don't depend on the format it was
+// decoded from
+func checkSpell(spell *Spell) []spellProblem {
+	var problems []spellProblem
+	if spell.Index == "" {
+		problems = append(problems, spellProblem{"", fmt.Sprintf("spell %s has no index", quoteName(spell.Name))})
+	}
+	if spell.Level < 0 || spell.Level > 9 {
+		problems = append(problems, spellProblem{"level", fmt.Sprintf("level %d is not between 0 and 9", spell.Level)})
+	}
+	hasMaterial := false
+	for _, c := range spell.Components {
+		if !validComponents[c] {
+			problems = append(problems, spellProblem{"components", fmt.Sprintf("unknown component %s, expected V, S or M", c)})
+		}
+		if c == "M" {
+			hasMaterial = true
+		}
+	}
+	if spell.Material != "" && !hasMaterial {
+		problems = append(problems, spellProblem{"material", "material is set, but M is not one of the components"})
+	}
+	return problems
+}
+
+// Returns byte offsets of the top level keys of a JSON object, relative to
+// the start of the object. Keys are lowercased.
+func keyOffsets(raw []byte) (map[string]int, error) {
+	keys := map[string]int{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("not an object")
+	}
+	for dec.More() {
+		start := skipSeparators(raw, int(dec.InputOffset()))
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := tok.(string)
+		keys[strings.ToLower(key)] = start
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// Skips whitespace and commas that separate JSON values
+func skipSeparators(data []byte, offset int) int {
+	for offset < len(data) && strings.IndexByte(" \t\r\n,", data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// Returns the line and the column at the byte offset, both starting from 1
+func lineColumn(data []byte, offset int) (line, col int) {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	lineStart := bytes.LastIndexByte(before, '\n') + 1
+	return line, utf8.RuneCount(before[lineStart:]) + 1
+}
+
+// Returns a friendlier message of errors returned while decoding a field
+func typeErrMessage(err error) string {
+	if e, ok := err.(*json.UnmarshalTypeError); ok {
+		return fmt.Sprintf("expected %s, got %s", e.Type, e.Value)
+	}
+	return err.Error()
+}
+
+func quoteName(name string) string {
+	if name == "" {
+		return "without a name"
+	}
+	return "\"" + name + "\""
+}
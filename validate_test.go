@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateSpells(t *testing.T) {
+	var tests = []struct {
+		json  string
+		want  []string
+		diags []string
+	}{
+		{`[
+    {"index": "fog", "level": 1},
+    {"name": "No Index", "level": 1},
+    {"index": "fog", "level": 2},
+    {"index": "wish", "level": 10, "components": ["V", "X"]},
+    {"index": "light", "material": "A firefly", "components": "V, S"},
+    {"index": "knock", "desc": 5, "ritual": "maybe"},
+    "meow",
+    {"index": "shield", "components": ["V", "S"]}
+]`, []string{"fog", "shield"}, []string{
+			"spells.json:3:5: spell \"No Index\" has no index",
+			"spells.json:4:6: duplicate index fog, first used at 2:6",
+			"spells.json:5:23: level 10 is not between 0 and 9",
+			"spells.json:5:36: unknown component X, expected V, S or M",
+			"spells.json:6:24: material is set, but M is not one of the components",
+			"spells.json:7:24: invalid desc: expected a string or an array of strings, got a number",
+			"spells.json:7:35: invalid ritual: expected a bool or \"yes\"/\"no\", got a string",
+			"spells.json:8:5: expected a spell object, got a string",
+		}},
+		// spells before a syntax error are loaded
+		{`[
+    {"index": "fog"},
+    {"index": "wish" "level": 9},
+    {"index": "shield"}
+]`, []string{"fog"}, []string{
+			"spells.json:3:22: invalid spell: invalid character '\"' after object key:value pair",
+		}},
+		{`{"index": "fog"}`, nil, []string{"spells.json:1:2: expected an array of spells"}},
+		{``, nil, []string{"spells.json:1:1: expected an array of spells: EOF"}},
+		{`[]`, nil, nil},
+	}
+
+	for _, test := range tests {
+		spells, diags := validateSpells("spells.json", []byte(test.json))
+		var output []string
+		for _, s := range spells {
+			output = append(output, s.Index)
+		}
+		if !reflect.DeepEqual(output, test.want) {
+			t.Errorf("Unexpected spells.\nhave: \"%v\"\nwant: \"%v\"", output, test.want)
+		}
+		var messages []string
+		for _, d := range diags {
+			messages = append(messages, d.String())
+		}
+		if !reflect.DeepEqual(messages, test.diags) {
+			t.Errorf("Unexpected diagnostics.\nhave: %#v\nwant: %#v", messages, test.diags)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// This file contains exports to virtual tabletops. Both are JSON arrays of
+// spells: Foundry VTT items of the dnd5e system, which can be imported into
+// a compendium, and Roll20 compendium entries. Casting times, durations and
+// ranges are free text in litch, so they are parsed into the structured
+// fields of Foundry as well as they can be. Text that can't be parsed is
+// kept in the description or the condition.
+
+// Writes values as indented JSON without escaping HTML in descriptions
+func writeVTTJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// foundryItem is a spell item of the Foundry VTT dnd5e system
+type foundryItem struct {
+	Name   string                       `json:"name"`
+	Type   string                       `json:"type"`
+	System foundrySpell                 `json:"system"`
+	Flags  map[string]map[string]string `json:"flags"`
+}
+
+type foundrySpell struct {
+	Description struct {
+		Value string `json:"value"`
+	} `json:"description"`
+	Source      string             `json:"source"`
+	Activation  foundryActivation  `json:"activation"`
+	Duration    foundryDuration    `json:"duration"`
+	Target      foundryTarget      `json:"target"`
+	Range       foundryRange       `json:"range"`
+	Level       int                `json:"level"`
+	School      string             `json:"school"`
+	Components  foundryComponents  `json:"components"`
+	Materials   foundryMaterials   `json:"materials"`
+	Preparation foundryPreparation `json:"preparation"`
+}
+
+type foundryActivation struct {
+	Type      string `json:"type"`
+	Cost      *int   `json:"cost"`
+	Condition string `json:"condition"`
+}
+
+type foundryDuration struct {
+	Value string `json:"value"`
+	Units string `json:"units"`
+}
+
+type foundryTarget struct {
+	Value *int   `json:"value"`
+	Units string `json:"units"`
+	Type  string `json:"type"`
+}
+
+type foundryRange struct {
+	Value *int   `json:"value"`
+	Long  *int   `json:"long"`
+	Units string `json:"units"`
+}
+
+type foundryComponents struct {
+	Vocal         bool `json:"vocal"`
+	Somatic       bool `json:"somatic"`
+	Material      bool `json:"material"`
+	Ritual        bool `json:"ritual"`
+	Concentration bool `json:"concentration"`
+}
+
+type foundryMaterials struct {
+	Value    string `json:"value"`
+	Consumed bool   `json:"consumed"`
+	Cost     int    `json:"cost"`
+	Supply   int    `json:"supply"`
+}
+
+type foundryPreparation struct {
+	Mode     string `json:"mode"`
+	Prepared bool   `json:"prepared"`
+}
+
+// Abbreviations of schools in the dnd5e system
+var foundrySchools = map[string]string{
+	"abjuration":    "abj",
+	"conjuration":   "con",
+	"divination":    "div",
+	"enchantment":   "enc",
+	"evocation":     "evo",
+	"illusion":      "ill",
+	"necromancy":    "nec",
+	"transmutation": "trs",
+}
+
+// Writes spells as Foundry VTT items
+func writeFoundry(w io.Writer, title string, spells Spells) error {
+	items := []foundryItem{}
+	for i := range spells {
+		items = append(items, foundrySpellItem(&spells[i]))
+	}
+	return writeVTTJSON(w, items)
+}
+
+func foundrySpellItem(s *Spell) foundryItem {
+	item := foundryItem{Name: s.Name, Type: "spell"}
+	sys := &item.System
+	sys.Description.Value = string(descHTML(s.Desc, ""))
+	if s.HigherLevel != "" {
+		sys.Description.Value += string(descHTML(s.HigherLevel, "At Higher Levels."))
+	}
+	sys.Source = sourceText(s)
+	sys.Activation = foundryCastingTime(s.CastingTime)
+	sys.Duration = foundrySpellDuration(s.Duration)
+	sys.Range, sys.Target = foundrySpellRange(s.Range)
+	sys.Level = s.Level
+	sys.School = foundrySchools[strings.ToLower(s.School.Name)]
+	for _, c := range s.Components {
+		switch c {
+		case "V":
+			sys.Components.Vocal = true
+		case "S":
+			sys.Components.Somatic = true
+		case "M":
+			sys.Components.Material = true
+		}
+	}
+	sys.Components.Ritual = s.Ritual
+	sys.Components.Concentration = s.Concentration
+	sys.Materials = foundryMaterials{Value: s.Material, Consumed: strings.Contains(strings.ToLower(s.Material), "consume"), Cost: materialCost(s.Material)}
+	sys.Preparation = foundryPreparation{Mode: "prepared"}
+	item.Flags = map[string]map[string]string{"litch": {"index": s.Index}}
+	return item
+}
+
+var (
+	// "1 action" or "1 reaction, which you take when..."
+	castingTimeRegexp = regexp.MustCompile(`(?i)^(\d+)\s+(bonus action|action|reaction|minute|hour)s?\b[,;]?\s*(.*)$`)
+	// "1 minute" or "Concentration, up to 10 minutes"
+	foundryDurationRegexp = regexp.MustCompile(`(?i)^(?:concentration,\s*)?(?:up to\s+)?(\d+)\s+(round|minute|hour|day|week|month|year)s?$`)
+	// "90 feet" or "1 mile"
+	distanceRegexp = regexp.MustCompile(`(?i)^(\d+)[\s-]+(foot|feet|ft\.?|mile|miles)$`)
+	// "Self (15-foot cone)"
+	areaRegexp = regexp.MustCompile(`(?i)^(self|touch)\s*\((\d+)[\s-]+(?:foot|feet)[\s-]+(?:radius\s+)?(cone|cube|cylinder|line|radius|sphere|square|wall)`)
+	// "worth at least 50 gp"
+	costRegexp = regexp.MustCompile(`(?i)([\d,]+)\s*gp`)
+)
+
+func foundryCastingTime(castingTime string) foundryActivation {
+	m := castingTimeRegexp.FindStringSubmatch(strings.TrimSpace(castingTime))
+	if m == nil {
+		return foundryActivation{Type: "special", Condition: castingTime}
+	}
+	cost, _ := strconv.Atoi(m[1])
+	kind := strings.ToLower(m[2])
+	if kind == "bonus action" {
+		kind = "bonus"
+	}
+	return foundryActivation{Type: kind, Cost: &cost, Condition: m[3]}
+}
+
+func foundrySpellDuration(duration string) foundryDuration {
+	d := strings.TrimSpace(duration)
+	switch lower := strings.ToLower(d); {
+	case lower == "":
+		return foundryDuration{}
+	case lower == "instantaneous":
+		return foundryDuration{Units: "inst"}
+	case strings.HasPrefix(lower, "until dispelled"), lower == "permanent":
+		return foundryDuration{Units: "perm"}
+	}
+	if m := foundryDurationRegexp.FindStringSubmatch(d); m != nil {
+		return foundryDuration{Value: m[1], Units: strings.ToLower(m[2])}
+	}
+	return foundryDuration{Units: "spec"}
+}
+
+func foundrySpellRange(rng string) (foundryRange, foundryTarget) {
+	r := strings.TrimSpace(rng)
+	if m := areaRegexp.FindStringSubmatch(r); m != nil {
+		size, _ := strconv.Atoi(m[2])
+		return foundryRange{Units: strings.ToLower(m[1])}, foundryTarget{Value: &size, Units: "ft", Type: strings.ToLower(m[3])}
+	}
+	switch lower := strings.ToLower(r); lower {
+	case "":
+		return foundryRange{}, foundryTarget{}
+	case "self", "touch":
+		return foundryRange{Units: lower}, foundryTarget{}
+	case "unlimited":
+		return foundryRange{Units: "any"}, foundryTarget{}
+	}
+	if m := distanceRegexp.FindStringSubmatch(r); m != nil {
+		value, _ := strconv.Atoi(m[1])
+		units := "ft"
+		if strings.HasPrefix(strings.ToLower(m[2]), "mile") {
+			units = "mi"
+		}
+		return foundryRange{Value: &value, Units: units}, foundryTarget{}
+	}
+	return foundryRange{Units: "spec"}, foundryTarget{}
+}
+
+// Returns the cost of a material component in gold pieces, 0 if it has none
+func materialCost(material string) int {
+	m := costRegexp.FindStringSubmatch(material)
+	if m == nil {
+		return 0
+	}
+	cost, _ := strconv.Atoi(strings.Replace(m[1], ",", "", -1))
+	return cost
+}
+
+// roll20Spell is a spell entry of the Roll20 compendium
+type roll20Spell struct {
+	Name    string          `json:"name"`
+	Content string          `json:"content"`
+	Data    roll20SpellData `json:"data"`
+}
+
+type roll20SpellData struct {
+	Category      string `json:"Category"`
+	Level         string `json:"Level"`
+	School        string `json:"School"`
+	CastingTime   string `json:"Casting Time"`
+	Range         string `json:"Range"`
+	Target        string `json:"Target,omitempty"`
+	Components    string `json:"Components"`
+	Material      string `json:"Material,omitempty"`
+	Duration      string `json:"Duration"`
+	Concentration string `json:"Concentration,omitempty"`
+	Ritual        string `json:"Ritual,omitempty"`
+	Classes       string `json:"Classes"`
+	HigherLevel   string `json:"Higher Spell Slot Desc,omitempty"`
+	Source        string `json:"Source,omitempty"`
+}
+
+// Writes spells as Roll20 compendium entries
+func writeRoll20(w io.Writer, title string, spells Spells) error {
+	entries := []roll20Spell{}
+	for i := range spells {
+		entries = append(entries, roll20SpellEntry(&spells[i]))
+	}
+	return writeVTTJSON(w, entries)
+}
+
+func roll20SpellEntry(s *Spell) roll20Spell {
+	data := roll20SpellData{
+		Category:    "Spells",
+		Level:       strconv.Itoa(s.Level),
+		School:      s.School.Name,
+		CastingTime: s.CastingTime,
+		Range:       s.Range,
+		Components:  strings.Join(s.Components, " "),
+		Material:    s.Material,
+		Duration:    s.Duration,
+		Classes:     joinNames(s.Classes),
+		HigherLevel: s.HigherLevel,
+		Source:      sourceText(s),
+	}
+	// Roll20 keeps the area apart from the range
+	if i := strings.Index(s.Range, "("); i > 0 && strings.HasSuffix(s.Range, ")") {
+		data.Range = strings.TrimSpace(s.Range[:i])
+		data.Target = s.Range[i+1 : len(s.Range)-1]
+	}
+	if s.Concentration {
+		data.Concentration = "Yes"
+		// the duration is shown next to the concentration mark
+		data.Duration = strings.TrimSpace(strings.TrimPrefix(data.Duration, "Concentration,"))
+		if strings.HasPrefix(data.Duration, "up to") {
+			data.Duration = "Up to" + data.Duration[len("up to"):]
+		}
+	}
+	if s.Ritual {
+		data.Ritual = "Yes"
+	}
+	return roll20Spell{Name: s.Name, Content: s.Desc, Data: data}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+// Spells with texts that the structured fields of virtual tabletops are
+// parsed from
+var VTTSpells = append(Spells{
+	{Index: "fire-shield", Name: "Fire Shield", Desc: "Thin and wispy flames wreathe your body.\n\nThe flames provide you with a **warm shield** or a *chill shield*.", Range: "Self", Components: []string{"V", "S", "M"}, Material: "A bit of phosphorus or a firefly.", Duration: "10 minutes", CastingTime: "1 action", Level: 4, School: struct{ Name string }{Name: "Evocation"}, Classes: []struct{ Name string }{{Name: "Wizard"}}},
+	{Index: "burning-hands", Name: "Burning Hands", Desc: "A thin sheet of flames shoots forth.", HigherLevel: "The damage increases by 1d6 for each slot level above 1st.", Range: "Self (15-foot cone)", Components: []string{"V", "S"}, Duration: "Instantaneous", CastingTime: "1 action", Level: 1, School: struct{ Name string }{Name: "Evocation"}, Classes: []struct{ Name string }{{Name: "Sorcerer"}, {Name: "Wizard"}}},
+	{Index: "counterspell", Name: "Counterspell", Desc: "You attempt to interrupt a creature.", Range: "60 feet", Components: []string{"S"}, Duration: "Instantaneous", CastingTime: "1 reaction, which you take when you see a creature within 60 feet of you casting a spell", Level: 3, School: struct{ Name string }{Name: "Abjuration"}},
+	{Index: "raise-dead", Name: "Raise Dead", Desc: "You return a dead creature to life.", Range: "Touch", Components: []string{"V", "S", "M"}, Material: "A diamond worth at least 500 gp, which the spell consumes", Duration: "Instantaneous", CastingTime: "1 hour", Level: 5, School: struct{ Name string }{Name: "Necromancy"}},
+	{Index: "detect-magic", Name: "Detect Magic", Desc: "You sense the presence of magic.", Range: "Self", Components: []string{"V", "S"}, Duration: "Concentration, up to 10 minutes", Concentration: true, Ritual: true, CastingTime: "1 action", Level: 1, School: struct{ Name string }{Name: "Divination"}},
+	{Index: "teleportation-circle", Name: "Teleportation Circle", Desc: "You draw a circle.", Range: "10 feet", Components: []string{"V", "M"}, Material: "Rare chalks and inks worth 50 gp, which the spell consumes", Duration: "1 round", CastingTime: "1 minute", Level: 5, School: struct{ Name string }{Name: "Conjuration"}},
+	{Index: "sending", Name: "Sending", Desc: "You send a short message.", Range: "Unlimited", Components: []string{"V", "S", "M"}, Material: "A short piece of fine copper wire", Duration: "1 round", CastingTime: "1 action", Level: 3, School: struct{ Name string }{Name: "Evocation"}},
+	{Index: "mirage-arcane", Name: "Mirage Arcane", Desc: "You make terrain look like another.", Range: "Sight", Components: []string{"V", "S"}, Duration: "10 days", CastingTime: "10 minutes", Level: 7, School: struct{ Name string }{Name: "Illusion"}},
+	{Index: "forbiddance", Name: "Forbiddance", Desc: "You create a ward.", Range: "Touch", Components: []string{"V", "S", "M"}, Material: "Powdered rubies worth at least 1,000 gp", Duration: "Until dispelled", Ritual: true, CastingTime: "10 minutes", Level: 6, School: struct{ Name string }{Name: "Abjuration"}},
+	{Index: "healing-word", Name: "Healing Word", Desc: "A creature regains hit points.", Range: "1 mile", Components: []string{"V"}, Duration: "Special", CastingTime: "1 bonus action", Level: 1, School: struct{ Name string }{Name: "Evocation"}},
+}, ExampleSpells...)
+
+// Compares exports of VTTSpells with files in testdata. Run the tests with
+// -update to write the files after a deliberate change.
+func TestVTTGolden(t *testing.T) {
+	for _, format := range []string{"foundry", "roll20"} {
+		var buf bytes.Buffer
+		if err := exportSpells(&buf, format, "Spells", VTTSpells); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		golden := filepath.Join("testdata", format+".golden.json")
+		if *updateGolden {
+			if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("%v, run the tests with -update to create it", err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%s export differs from %s:\n%s", format, golden, buf.String())
+		}
+	}
+}
+
+func TestFoundryCastingTime(t *testing.T) {
+	one, ten := 1, 10
+	tests := []struct {
+		in   string
+		want foundryActivation
+	}{
+		{"1 action", foundryActivation{Type: "action", Cost: &one}},
+		{"1 bonus action", foundryActivation{Type: "bonus", Cost: &one}},
+		{"1 reaction, which you take when you fall", foundryActivation{Type: "reaction", Cost: &one, Condition: "which you take when you fall"}},
+		{"10 minutes", foundryActivation{Type: "minute", Cost: &ten}},
+		{"1 Hour", foundryActivation{Type: "hour", Cost: &one}},
+		{"Whenever you like", foundryActivation{Type: "special", Condition: "Whenever you like"}},
+	}
+	for _, test := range tests {
+		if got := foundryCastingTime(test.in); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %+v, want %+v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestFoundrySpellDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want foundryDuration
+	}{
+		{"", foundryDuration{}},
+		{"Instantaneous", foundryDuration{Units: "inst"}},
+		{"Until dispelled or triggered", foundryDuration{Units: "perm"}},
+		{"1 round", foundryDuration{Value: "1", Units: "round"}},
+		{"Concentration, up to 1 minute", foundryDuration{Value: "1", Units: "minute"}},
+		{"Up to 8 hours", foundryDuration{Value: "8", Units: "hour"}},
+		{"Special", foundryDuration{Units: "spec"}},
+	}
+	for _, test := range tests {
+		if got := foundrySpellDuration(test.in); got != test.want {
+			t.Errorf("%q: got %+v, want %+v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestFoundrySpellRange(t *testing.T) {
+	ninety, one, fifteen, twenty := 90, 1, 15, 20
+	tests := []struct {
+		in         string
+		wantRange  foundryRange
+		wantTarget foundryTarget
+	}{
+		{"90 feet", foundryRange{Value: &ninety, Units: "ft"}, foundryTarget{}},
+		{"1 mile", foundryRange{Value: &one, Units: "mi"}, foundryTarget{}},
+		{"Touch", foundryRange{Units: "touch"}, foundryTarget{}},
+		{"Self (15-foot cone)", foundryRange{Units: "self"}, foundryTarget{Value: &fifteen, Units: "ft", Type: "cone"}},
+		{"Self (20-foot-radius sphere)", foundryRange{Units: "self"}, foundryTarget{Value: &twenty, Units: "ft", Type: "sphere"}},
+		{"Unlimited", foundryRange{Units: "any"}, foundryTarget{}},
+		{"Sight", foundryRange{Units: "spec"}, foundryTarget{}},
+	}
+	for _, test := range tests {
+		r, target := foundrySpellRange(test.in)
+		if !reflect.DeepEqual(r, test.wantRange) || !reflect.DeepEqual(target, test.wantTarget) {
+			t.Errorf("%q: got %+v %+v, want %+v %+v", test.in, r, target, test.wantRange, test.wantTarget)
+		}
+	}
+}
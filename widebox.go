@@ -14,6 +14,8 @@ type WideBox struct {
 	lvlbox       *tview.TextView
 	ritualbox    *tview.TextView
 	concentrbox  *tview.TextView
+	castbox      *tview.TextView
+	docbox       *tview.TextView
 	classbox     *tview.TextView
 	timecastbox  *tview.TextView
 	rangebox     *tview.TextView
@@ -46,6 +48,10 @@ func getWideBox() *WideBox {
 	box.ritualbox = ritualbox
 	concentrbox := newTextViewRight()
 	box.concentrbox = concentrbox
+	castbox := newTextViewRight()
+	box.castbox = castbox
+	docbox := newTextViewLeft()
+	box.docbox = docbox
 	classbox := newTextViewLeft()
 	box.classbox = classbox
 	timecastbox := newTextViewMid()
@@ -60,18 +66,20 @@ func getWideBox() *WideBox {
 	box.descbox = descbox
 
 	grid := tview.NewGrid().
-		SetRows(1, 1, 2, 3, 4).
+		SetRows(1, 1, 1, 2, 3, 4).
 		SetColumns(-1, -1).
 		AddItem(namebox, 0, 0, 1, 1, 1, 1, false).
 		AddItem(lvlbox, 1, 0, 1, 1, 1, 1, false).
 		AddItem(ritualbox, 0, 1, 1, 1, 1, 1, false).
 		AddItem(concentrbox, 1, 1, 1, 1, 1, 1, false).
-		AddItem(classbox, 2, 0, 1, 2, 1, 1, false).
-		AddItem(timecastbox, 3, 0, 1, 1, 1, 1, false).
-		AddItem(rangebox, 3, 1, 1, 1, 1, 1, false).
-		AddItem(componentbox, 4, 0, 1, 1, 1, 1, false).
-		AddItem(durationbox, 4, 1, 1, 1, 1, 1, false).
-		AddItem(descbox, 5, 0, 1, 2, 1, 1, false)
+		AddItem(docbox, 2, 0, 1, 1, 1, 1, false).
+		AddItem(castbox, 2, 1, 1, 1, 1, 1, false).
+		AddItem(classbox, 3, 0, 1, 2, 1, 1, false).
+		AddItem(timecastbox, 4, 0, 1, 1, 1, 1, false).
+		AddItem(rangebox, 4, 1, 1, 1, 1, 1, false).
+		AddItem(componentbox, 5, 0, 1, 1, 1, 1, false).
+		AddItem(durationbox, 5, 1, 1, 1, 1, 1, false).
+		AddItem(descbox, 6, 0, 1, 2, 1, 1, false)
 	grid.SetBorder(true)
 
 	box.grid = grid
@@ -100,6 +108,8 @@ func (b *WideBox) SetSpell(s *Spell) {
 	b.SetLevel(s.Level, s.School.Name)
 	b.SetRitual(s.Ritual)
 	b.SetConentration(s.Concentration)
+	b.SetCastable("")
+	b.SetDocument(s.Document, s.Page)
 	b.SetClasses(s.Classes, s.Subclasses)
 	b.SetCastingTime(s.CastingTime)
 	b.SetRange(s.Range)
@@ -110,6 +120,18 @@ func (b *WideBox) SetSpell(s *Spell) {
 	b.descbox.ScrollToBeginning()
 }
 
+// Shows arbitrary text instead of a spell, per example the help or
+// diagnostics. Title is shown in place of the spell name.
+func (b *WideBox) SetInfo(title, text string) {
+	b.SetSpell(nil)
+	b.SetName(title)
+	b.timecastbox.SetText("")
+	b.rangebox.SetText("")
+	b.componentbox.SetText("")
+	b.durationbox.SetText("")
+	b.descbox.SetText(text)
+}
+
 func (b *WideBox) SetName(s string) {
 	b.namebox.SetText("[#ff5522::bu]" + s)
 }
@@ -142,6 +164,25 @@ func (b *WideBox) SetConentration(c bool) {
 	b.concentrbox.SetText("")
 }
 
+// Sets whether the spell can be cast with the slots that are left. The text
+// should be already colored.
+func (b *WideBox) SetCastable(s string) {
+	b.castbox.SetText(s)
+}
+
+// Sets the document the spell is published in and the page of it. The title
+// is shown if it is known, the slug otherwise.
+func (b *WideBox) SetDocument(doc Document, page string) {
+	title := doc.Title
+	if title == "" {
+		title = doc.Slug
+	}
+	if page != "" && title != "" {
+		title += ", "
+	}
+	b.docbox.SetText("[gray]" + tview.Escape(title+page))
+}
+
 // Sets classes and subclasses
 func (b *WideBox) SetClasses(c []struct{ Name string }, s []struct{ Name string }) {
 	var names []string
@@ -179,11 +220,13 @@ func (b *WideBox) SetDuration(d string) {
 	b.durationbox.SetText("[orange]Duration[white]\n" + d)
 }
 
-// Sets the spell description and at higher levels description
+// Sets the spell description and at higher levels description. Dice in them
+// are highlighted and numbered so that they can be rolled with :roll.
 func (b *WideBox) SetDescription(d string, hl string) {
-	text := d
+	dice := findDice(d)
+	text := highlightDice(d, dice, 1)
 	if hl != "" {
-		text += "\n\n[::b]At higher levels: [::-]" + hl
+		text += "\n\n[::b]At higher levels: [::-]" + highlightDice(hl, findDice(hl), len(dice)+1)
 	}
 	b.descbox.SetText(text)
 }